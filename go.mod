@@ -5,6 +5,7 @@ go 1.21.5
 require (
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.24
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.20
+	github.com/docker/go-units v0.4.0
 	github.com/stretchr/testify v1.8.1
 )
 
@@ -12,7 +13,6 @@ require (
 	github.com/bitrise-io/go-utils v1.0.1 // indirect
 	github.com/bmatcuk/doublestar/v4 v4.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/docker/go-units v0.4.0 // indirect
 	github.com/gofrs/uuid v4.3.1+incompatible // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-retryablehttp v0.7.1 // indirect
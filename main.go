@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
-	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/exitcode"
 	"github.com/bitrise-io/go-utils/v2/log"
@@ -21,13 +24,33 @@ func run() exitcode.ExitCode {
 	logger := log.NewLogger()
 	envRepo := env.NewRepository()
 	inputParser := stepconf.NewInputParser(envRepo)
-	cmdFactory := command.NewFactory(envRepo)
+	cmdFactory := step.NewCancelableCommandFactory(envRepo)
 	pathChecker := pathutil.NewPathChecker()
 	pathProvider := pathutil.NewPathProvider()
 	pathModifier := pathutil.NewPathModifier()
-	dockerBuildPushStep := step.New(logger, inputParser, cmdFactory, pathChecker, pathProvider, pathModifier, envRepo)
+	dockerBuildPushStep := step.New(logger, inputParser, cmdFactory, cmdFactory, pathChecker, pathProvider, pathModifier, envRepo)
+
+	// When the step is aborted, the in-flight docker command is interrupted and Run cleans up the builder
+	// and temporary folders on its way out, instead of the process exiting immediately.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			logger.Warnf("Received %s, aborting...", sig)
+			cmdFactory.CancelRunning(fmt.Errorf("%w by %s", step.ErrAborted, sig))
+		}
+	}()
 
 	if err := dockerBuildPushStep.Run(); err != nil {
+		var phaseErr *step.PhaseError
+		if errors.As(err, &phaseErr) {
+			logger.Println()
+			logger.Errorf("Failed phase: %s", phaseErr.Phase)
+			logger.Errorf(err.Error())
+			return phaseErr.ExitCode()
+		}
+
 		logger.Errorf(err.Error())
 		return exitcode.Failure
 	}
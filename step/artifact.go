@@ -0,0 +1,124 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const imageArtifactPathOutputKey = "DOCKER_IMAGE_ARTIFACT_PATH"
+
+const (
+	outputTypeNone            = "none"
+	outputTypeDockerTar       = "docker-tar"
+	outputTypeOCILayout       = "oci-layout"
+	outputTypeLocalFilesystem = "local-filesystem"
+
+	outputCompressionNone = "none"
+)
+
+// ImageArtifact is the image exported to the deploy directory, besides loading or pushing it.
+type ImageArtifact struct {
+	Path string
+	// Output is the buildx --output value exporting the image to Path
+	Output string
+}
+
+// ParseImageArtifact returns the artifact configured by the output_type and output_compression inputs,
+// or false if no artifact is exported. The artifact is named after the first tag.
+func ParseImageArtifact(input Input, deployDir string) (ImageArtifact, bool, error) {
+	if input.OutputType == "" || input.OutputType == outputTypeNone {
+		return ImageArtifact{}, false, nil
+	}
+	if deployDir == "" {
+		return ImageArtifact{}, false, fmt.Errorf("BITRISE_DEPLOY_DIR is not set, it is required by output_type %s", input.OutputType)
+	}
+
+	compressed := input.OutputCompression != "" && input.OutputCompression != outputCompressionNone
+	name := imageArtifactName(strings.Split(input.Tags, "\n")[0])
+
+	var artifact ImageArtifact
+	switch input.OutputType {
+	case outputTypeDockerTar:
+		artifact.Path = filepath.Join(deployDir, name+".docker.tar")
+		artifact.Output = "type=docker,dest=" + artifact.Path
+	case outputTypeOCILayout:
+		artifact.Path = filepath.Join(deployDir, name+".oci.tar")
+		artifact.Output = "type=oci,dest=" + artifact.Path
+	case outputTypeLocalFilesystem:
+		if compressed {
+			return ImageArtifact{}, false, fmt.Errorf("output_compression is not supported by output_type %s", input.OutputType)
+		}
+		artifact.Path = filepath.Join(deployDir, name)
+		artifact.Output = "type=local,dest=" + artifact.Path
+	default:
+		return ImageArtifact{}, false, fmt.Errorf("unknown output type: %s", input.OutputType)
+	}
+
+	if compressed {
+		// The layers are compressed even if they are already cached with a different compression
+		artifact.Output += fmt.Sprintf(",compression=%s,force-compression=true", input.OutputCompression)
+	}
+
+	return artifact, true, nil
+}
+
+// imageArtifactName turns an image reference like `registry:5000/team/app:1.0` into `app-1.0`.
+func imageArtifactName(image string) string {
+	name := image[strings.LastIndex(image, "/")+1:]
+	return strings.NewReplacer(":", "-", "@", "-").Replace(name)
+}
+
+// exportImageArtifact exports the image built by build to the deploy directory.
+// The build steps are served from the cache of the builder, so only the export is run again.
+func (step DockerBuildPushStep) exportImageArtifact(input Input, target buildxTarget, artifact ImageArtifact, built BuildMetadata, output io.Writer) error {
+	step.logger.Println()
+	step.logger.Infof("Exporting image artifact...")
+
+	if err := os.MkdirAll(filepath.Dir(artifact.Path), 0755); err != nil {
+		return fmt.Errorf("create deploy dir: %w", err)
+	}
+	// The local exporter writes into the existing directory, leaving files of a previous export behind
+	if err := os.RemoveAll(artifact.Path); err != nil {
+		return fmt.Errorf("remove previous artifact: %w", err)
+	}
+
+	metadataFile, err := step.createMetadataFile("export-metadata")
+	if err != nil {
+		return err
+	}
+	defer step.removeTempDir(filepath.Dir(metadataFile))
+
+	args := step.buildxBuildArgs(input, target, nil, []string{"--output", artifact.Output, "--metadata-file", metadataFile}, true)
+	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+	buildxCmd := step.commandFactory.Create("docker", args, &command.Opts{
+		Stdout: output,
+		Stderr: output,
+	})
+	if err := buildxCmd.Run(); err != nil {
+		return fmt.Errorf("export image with buildx: %w", err)
+	}
+
+	exported, err := readBuildMetadata(metadataFile)
+	if err != nil {
+		return err
+	}
+	if err := CheckRebuild(built, exported); err != nil {
+		return err
+	}
+
+	step.logger.Donef("Image exported to %s", artifact.Path)
+
+	exporter := export.NewExporter(step.commandFactory)
+	if err := exporter.ExportOutput(imageArtifactPathOutputKey, artifact.Path); err != nil {
+		return fmt.Errorf("export %s: %w", imageArtifactPathOutputKey, err)
+	}
+
+	return nil
+}
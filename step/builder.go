@@ -0,0 +1,354 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+)
+
+const (
+	builderNameOutputKey = "DOCKER_BUILDX_BUILDER"
+
+	driverDocker          = "docker"
+	driverDockerContainer = "docker-container"
+	driverRemote          = "remote"
+)
+
+type buildxBuilder struct {
+	name string
+	// created is true when the builder was created by this step run,
+	// builders attached by name are never removed by the step.
+	created bool
+	// tempDir holds files referenced by the builder for its whole lifetime, like TLS certificates
+	tempDir string
+	// container is the BuildKit container started by the step, for builders whose container is not managed by buildx
+	container string
+}
+
+// buildkitContainer returns the name of the container running the BuildKit daemon of the first node.
+func (b buildxBuilder) buildkitContainer() string {
+	if b.container != "" {
+		return b.container
+	}
+	// buildx names the container after the first node of the builder
+	return fmt.Sprintf("buildx_buildkit_%s0", b.name)
+}
+
+func (step DockerBuildPushStep) initializeBuildkit(input Input) (buildxBuilder, error) {
+	if input.Driver == driverDocker {
+		// The docker driver cannot be created, it is the builder embedded into the Docker daemon
+		// and is named after the active docker context
+		name, err := step.dockerContextName()
+		if err != nil {
+			return buildxBuilder{}, err
+		}
+		step.logger.Printf("Using the docker driver of the %s context", name)
+		if input.UseBitriseCache {
+			step.logger.Warnf("The docker driver only supports exporting the local cache when the containerd image store is enabled")
+		}
+		return buildxBuilder{name: name}, nil
+	}
+
+	builder, err := step.attachOrCreateBuilder(input)
+	if err != nil {
+		return buildxBuilder{}, err
+	}
+
+	if input.Driver == driverRemote || input.BuilderNodes != "" || input.Rootless {
+		if err := step.checkBuilderHealth(builder.name); err != nil {
+			step.releaseBuilder(builder, input.KeepBuilder)
+			return buildxBuilder{}, err
+		}
+	}
+
+	return builder, nil
+}
+
+func (step DockerBuildPushStep) attachOrCreateBuilder(input Input) (buildxBuilder, error) {
+	nodes, err := parseBuilderNodes(input.BuilderNodes)
+	if err != nil {
+		return buildxBuilder{}, fmt.Errorf("parse builder nodes: %w", err)
+	}
+
+	if input.BuilderName != "" {
+		if step.builderExists(input.BuilderName) {
+			step.logger.Printf("Using existing buildx instance %s", input.BuilderName)
+			if len(nodes) > 0 {
+				step.logger.Warnf("Builder nodes are only appended to builders created by the step, the existing nodes of %s are used", input.BuilderName)
+			}
+			if config := buildkitdConfigInputs(input); len(config) > 0 {
+				step.logger.Warnf("%s only configure builders created by the step, the BuildKit daemon configuration of %s is used",
+					strings.Join(config, ", "), input.BuilderName)
+			}
+			return buildxBuilder{name: input.BuilderName}, nil
+		}
+		step.logger.Printf("Buildx instance %s not found, creating it", input.BuilderName)
+	}
+
+	var tempDir string
+	var extraDriverOpts []string
+	if input.Driver == driverRemote {
+		opts, dir, err := RemoteDriverOpts(input, step.pathProvider.CreateTempDir)
+		tempDir = dir
+		if err != nil {
+			step.removeTempDir(tempDir)
+			return buildxBuilder{}, fmt.Errorf("remote driver options: %w", err)
+		}
+		extraDriverOpts = opts
+	}
+	extraDriverOpts = append(extraDriverOpts, ProxyDriverOpts(input, step.envRepo.Get)...)
+
+	caCerts, err := step.builderCACerts(input)
+	if err != nil {
+		step.removeTempDir(tempDir)
+		return buildxBuilder{}, err
+	}
+
+	if input.Rootless {
+		return step.createRootlessBuilder(input, caCerts)
+	}
+
+	nodeArgs, configDir, err := step.builderNodeArgs(input, extraDriverOpts)
+	if err != nil {
+		step.removeTempDir(tempDir)
+		return buildxBuilder{}, err
+	}
+	// buildx reads the configuration when a node is created, the appended nodes need it as well
+	defer step.removeTempDir(configDir)
+
+	name, err := step.createBuilder(input, nodeArgs)
+	if err != nil {
+		step.removeTempDir(tempDir)
+		return buildxBuilder{}, err
+	}
+	builder := buildxBuilder{name: name, created: true, tempDir: tempDir}
+
+	if input.Driver == driverDockerContainer {
+		if err := step.installBuilderCACerts(builder, caCerts); err != nil {
+			step.releaseBuilder(builder, false)
+			return buildxBuilder{}, fmt.Errorf("install CA certificates: %w", err)
+		}
+	} else if len(caCerts) > 0 {
+		step.logger.Warnf("CA bundles are only installed into builders of the %s driver", driverDockerContainer)
+	}
+
+	if len(nodes) > 0 {
+		if err := step.appendBuilderNodes(input, name, nodes, nodeArgs); err != nil {
+			step.releaseBuilder(builder, false)
+			return buildxBuilder{}, err
+		}
+	}
+
+	return builder, nil
+}
+
+// releaseBuilder removes the builder if it was created by the step and it should not be kept.
+func (step DockerBuildPushStep) releaseBuilder(builder buildxBuilder, keep bool) {
+	if !builder.created {
+		step.logger.Printf("Leaving buildx instance %s in place, it was not created by the step", builder.name)
+		return
+	}
+	if keep {
+		step.logger.Printf("Keeping buildx instance %s", builder.name)
+		return
+	}
+
+	if err := step.destroyContainer(builder.name); err != nil {
+		step.logger.Errorf("destroy buildx instance: %s", err)
+	}
+	if builder.container != "" {
+		step.removeBuildkitContainer(builder.container)
+	}
+	step.removeTempDir(builder.tempDir)
+}
+
+func (step DockerBuildPushStep) removeTempDir(dir string) {
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		step.logger.Warnf("Failed to remove %s: %s", dir, err)
+	}
+}
+
+// builderNodeArgs returns the arguments configuring the BuildKit daemon of every node of the builder,
+// and the temp dir of the buildkitd.toml referenced by them.
+func (step DockerBuildPushStep) builderNodeArgs(input Input, extraDriverOpts []string) ([]string, string, error) {
+	buildkitdConfig, err := ParseBuildkitdConfig(input)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse buildkitd config: %w", err)
+	}
+
+	var configPath string
+	if !buildkitdConfig.IsEmpty() {
+		if input.Driver != driverDockerContainer {
+			return nil, "", fmt.Errorf("the BuildKit daemon configuration is only supported by the %s driver", driverDockerContainer)
+		}
+
+		configPath, err = step.writeBuildkitdConfig(buildkitdConfig)
+		if err != nil {
+			return nil, "", fmt.Errorf("write buildkitd config: %w", err)
+		}
+	}
+
+	args := BuilderNodeArgs(append(DriverOpts(input), extraDriverOpts...), configPath)
+	if configPath == "" {
+		return args, "", nil
+	}
+	return args, filepath.Dir(configPath), nil
+}
+
+// BuilderNodeArgs returns the `buildx create` arguments shared by the first node and the appended nodes of the builder.
+func BuilderNodeArgs(driverOpts []string, configPath string) []string {
+	var args []string
+	for _, opt := range driverOpts {
+		args = append(args, "--driver-opt", opt)
+	}
+
+	for _, opt := range driverOpts {
+		// Using the host network from the build requires the matching entitlement on the daemon side too
+		if opt == "network=host" {
+			args = append(args, "--buildkitd-flags", "--allow-insecure-entitlement network.host")
+			break
+		}
+	}
+
+	if configPath != "" {
+		args = append(args, "--config", configPath)
+	}
+
+	return args
+}
+
+func (step DockerBuildPushStep) createBuilder(input Input, nodeArgs []string) (string, error) {
+	args := []string{
+		"buildx", "create", "--use",
+	}
+
+	if input.BuilderName != "" {
+		args = append(args, "--name", input.BuilderName)
+	}
+
+	args = append(args, "--driver", input.Driver)
+
+	if input.BuildkitImage != "" && !strings.Contains(input.BuildkitImage, "@sha256:") {
+		step.logger.Warnf("The BuildKit image %s is not pinned by digest, builds might not be reproducible", input.BuildkitImage)
+	}
+
+	args = append(args, nodeArgs...)
+
+	if input.Driver == driverRemote {
+		args = append(args, input.BuildkitEndpoint)
+	}
+
+	return step.runBuildxCreate(args)
+}
+
+func (step DockerBuildPushStep) runBuildxCreate(args []string) (string, error) {
+	createCmd := step.commandFactory.Create("docker", args, nil)
+
+	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+	out, err := createCmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("create buildx instance %s: %w", out, err)
+	}
+	return out, nil
+}
+
+func (step DockerBuildPushStep) dockerContextName() (string, error) {
+	cmd := step.commandFactory.Create("docker", []string{"context", "show"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("get current docker context %s: %w", out, err)
+	}
+	return out, nil
+}
+
+func (step DockerBuildPushStep) builderExists(name string) bool {
+	args := []string{
+		"buildx", "inspect", name,
+	}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		step.logger.Debugf("Inspect buildx instance %s: %s", name, out)
+		return false
+	}
+	return true
+}
+
+func (step DockerBuildPushStep) destroyContainer(container string) error {
+	args := []string{
+		"buildx", "rm", "--force", container,
+	}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("remove buildx instance %s: %w", out, err)
+	}
+	return nil
+}
+
+func (step DockerBuildPushStep) exportBuilderName(name string) error {
+	exporter := export.NewExporter(step.commandFactory)
+	return exporter.ExportOutput(builderNameOutputKey, name)
+}
+
+// ValidateDriverInputs checks that the builder inputs are supported by the selected driver.
+func ValidateDriverInputs(input Input) error {
+	switch input.Driver {
+	case driverDocker:
+		if input.DriverOpts != "" || input.BuildkitImage != "" || input.BuildxHostNetwork {
+			return fmt.Errorf("driver_opts, buildkit_image and buildx_host_network are not supported by the %s driver", driverDocker)
+		}
+		if input.BuilderNodes != "" {
+			return fmt.Errorf("builder_nodes are not supported by the %s driver", driverDocker)
+		}
+		// The BuildKit daemon of the Docker daemon is configured by the daemon.json of Docker
+		if config := buildkitdConfigInputs(input); len(config) > 0 {
+			return fmt.Errorf("%s are not supported by the %s driver", strings.Join(config, ", "), driverDocker)
+		}
+	case driverRemote:
+		if err := validateRemoteInputs(input); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRootlessInputs(input); err != nil {
+		return err
+	}
+	if err := validateResourceInputs(input); err != nil {
+		return err
+	}
+
+	// These are parsed again when the builder is created, parsing them here reports invalid values as validation errors
+	if _, err := parseBuilderNodes(input.BuilderNodes); err != nil {
+		return fmt.Errorf("parse builder nodes: %w", err)
+	}
+	if _, err := ParseBuildkitdConfig(input); err != nil {
+		return fmt.Errorf("parse buildkitd config: %w", err)
+	}
+	return nil
+}
+
+// DriverOpts returns the --driver-opt values for the builder, including the BuildKit image and resource limits if they are specified.
+func DriverOpts(input Input) []string {
+	var opts []string
+	if input.BuildkitImage != "" {
+		opts = append(opts, fmt.Sprintf("image=%s", input.BuildkitImage))
+	}
+
+	opts = append(opts, ResourceDriverOpts(input)...)
+	opts = append(opts, splitLines(input.DriverOpts)...)
+
+	if input.BuildxHostNetwork && !slices.Contains(opts, "network=host") {
+		opts = append(opts, "network=host")
+	}
+
+	return opts
+}
@@ -0,0 +1,187 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+type registryConfig struct {
+	host     string
+	mirrors  []string
+	insecure bool
+	caCerts  []string
+}
+
+// BuildkitdConfig holds the BuildKit daemon settings rendered into buildkitd.toml.
+type BuildkitdConfig struct {
+	registries []*registryConfig
+	// gcKeepStorageMB is the storage limit of the default garbage collection policy in megabytes
+	gcKeepStorageMB int64
+	maxParallelism  int
+}
+
+// buildkitdConfigInputs returns the set inputs which are rendered into buildkitd.toml.
+func buildkitdConfigInputs(input Input) []string {
+	var inputs []string
+	check := func(name string, set bool) {
+		if set {
+			inputs = append(inputs, name)
+		}
+	}
+
+	check("registry_mirrors", input.RegistryMirrors != "")
+	check("insecure_registries", input.InsecureRegistries != "")
+	check("registry_ca_certs", input.RegistryCACerts != "")
+	check("gc_keep_storage", input.GCKeepStorage != "")
+	check("max_parallelism", input.MaxParallelism != 0)
+
+	return inputs
+}
+
+func ParseBuildkitdConfig(input Input) (BuildkitdConfig, error) {
+	var config BuildkitdConfig
+
+	for _, line := range splitLines(input.RegistryMirrors) {
+		host, mirror, found := strings.Cut(line, "=")
+		if !found || host == "" || mirror == "" {
+			return BuildkitdConfig{}, fmt.Errorf("invalid registry mirror (%s), expected format: registry=mirror", line)
+		}
+		registry := config.registry(host)
+		registry.mirrors = append(registry.mirrors, mirror)
+	}
+
+	for _, host := range splitLines(input.InsecureRegistries) {
+		config.registry(host).insecure = true
+	}
+
+	for _, line := range splitLines(input.RegistryCACerts) {
+		host, path, found := strings.Cut(line, "=")
+		if !found || host == "" || path == "" {
+			return BuildkitdConfig{}, fmt.Errorf("invalid registry CA certificate (%s), expected format: registry=path", line)
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return BuildkitdConfig{}, fmt.Errorf("resolve CA certificate path %s: %w", path, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return BuildkitdConfig{}, fmt.Errorf("CA certificate of %s: %w", host, err)
+		}
+		registry := config.registry(host)
+		registry.caCerts = append(registry.caCerts, absPath)
+	}
+
+	if input.GCKeepStorage != "" {
+		size, err := units.FromHumanSize(input.GCKeepStorage)
+		if err != nil {
+			return BuildkitdConfig{}, fmt.Errorf("invalid gc keep storage (%s): %w", input.GCKeepStorage, err)
+		}
+		config.gcKeepStorageMB = size / units.MB
+		if config.gcKeepStorageMB < 1 {
+			return BuildkitdConfig{}, fmt.Errorf("gc keep storage (%s) must be at least 1MB", input.GCKeepStorage)
+		}
+	}
+
+	if input.MaxParallelism < 0 {
+		return BuildkitdConfig{}, fmt.Errorf("max parallelism (%d) must not be negative", input.MaxParallelism)
+	}
+	config.maxParallelism = input.MaxParallelism
+
+	return config, nil
+}
+
+func (c *BuildkitdConfig) registry(host string) *registryConfig {
+	for _, registry := range c.registries {
+		if registry.host == host {
+			return registry
+		}
+	}
+
+	registry := &registryConfig{host: host}
+	c.registries = append(c.registries, registry)
+	return registry
+}
+
+func (c BuildkitdConfig) IsEmpty() bool {
+	return len(c.registries) == 0 && c.gcKeepStorageMB == 0 && c.maxParallelism == 0
+}
+
+// Render returns the configuration in the buildkitd.toml format.
+func (c BuildkitdConfig) Render() string {
+	var b strings.Builder
+
+	if c.gcKeepStorageMB > 0 || c.maxParallelism > 0 {
+		b.WriteString("[worker.oci]\n")
+		if c.gcKeepStorageMB > 0 {
+			b.WriteString("  gc = true\n")
+			fmt.Fprintf(&b, "  gckeepstorage = %d\n", c.gcKeepStorageMB)
+		}
+		if c.maxParallelism > 0 {
+			fmt.Fprintf(&b, "  max-parallelism = %d\n", c.maxParallelism)
+		}
+	}
+
+	for _, registry := range c.registries {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[registry.%q]\n", registry.host)
+		if len(registry.mirrors) > 0 {
+			fmt.Fprintf(&b, "  mirrors = %s\n", tomlStringArray(registry.mirrors))
+		}
+		if registry.insecure {
+			b.WriteString("  http = true\n")
+			b.WriteString("  insecure = true\n")
+		}
+		if len(registry.caCerts) > 0 {
+			fmt.Fprintf(&b, "  ca = %s\n", tomlStringArray(registry.caCerts))
+		}
+	}
+
+	return b.String()
+}
+
+// writeBuildkitdConfig renders the config into a temporary buildkitd.toml and returns its path.
+// buildx copies the file (and the referenced CA certificates) into the builder on creation,
+// so the temporary directory can be removed right after the builder is created.
+func (step DockerBuildPushStep) writeBuildkitdConfig(config BuildkitdConfig) (string, error) {
+	dir, err := step.pathProvider.CreateTempDir("buildkitd")
+	if err != nil {
+		return "", fmt.Errorf("create temp dir: %w", err)
+	}
+
+	path := filepath.Join(dir, "buildkitd.toml")
+	content := config.Render()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+
+	step.logger.Printf("Effective BuildKit daemon configuration:")
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		step.logger.Printf("  %s", line)
+	}
+
+	return path, nil
+}
+
+func tomlStringArray(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
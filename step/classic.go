@@ -0,0 +1,132 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// ClassicBuilderUnsupportedInputs returns the inputs which cannot be translated to a `docker build` call
+// of the classic builder, used when the buildx plugin is not available.
+func ClassicBuilderUnsupportedInputs(input Input) []string {
+	var unsupported []string
+	if input.UseBitriseCache {
+		unsupported = append(unsupported, "use_bitrise_cache")
+	}
+	if input.CacheTo != "" {
+		unsupported = append(unsupported, "cache_to")
+	}
+	if input.OutputType != "" && input.OutputType != outputTypeNone {
+		unsupported = append(unsupported, "output_type")
+	}
+	if input.VerifyPush {
+		unsupported = append(unsupported, "verify_push")
+	}
+	if input.MirrorRegistries != "" {
+		unsupported = append(unsupported, "mirror_registries")
+	}
+	for _, cacheFrom := range splitLines(input.CacheFrom) {
+		if _, ok := registryCacheRef(cacheFrom); !ok {
+			unsupported = append(unsupported, "cache_from")
+			break
+		}
+	}
+
+	// Images can be loaded into the daemon, but named build contexts are not supported
+	unsupported = append(unsupported, imageInputsUnsupportedByEngine(input, true)...)
+
+	return append(unsupported, builderInputs(input)...)
+}
+
+// registryCacheRef translates a buildx registry cache source or destination to the image reference
+// accepted by the --cache-from and --cache-to flags of builders other than buildx.
+func registryCacheRef(cache string) (string, bool) {
+	if !strings.Contains(cache, "=") {
+		return cache, true
+	}
+
+	var ref string
+	for _, attribute := range strings.Split(cache, ",") {
+		key, value, _ := strings.Cut(attribute, "=")
+		switch key {
+		case "type":
+			if value != "registry" {
+				return "", false
+			}
+		case "ref":
+			ref = value
+		}
+	}
+
+	return ref, ref != ""
+}
+
+func (step DockerBuildPushStep) classicBuild(input Input, output io.Writer) error {
+	step.logger.Infof("Building docker image with the classic builder...")
+
+	imageInputs, err := ParseImageInputs(input.ImageInputs)
+	if err != nil {
+		return err
+	}
+	if err := step.loadImageInputs("docker", imageInputs); err != nil {
+		return fmt.Errorf("load image inputs: %w", err)
+	}
+
+	args := []string{
+		"build",
+		"--progress=plain",
+	}
+
+	for _, arg := range splitLines(input.BuildArg) {
+		args = append(args, "--build-arg", arg)
+	}
+
+	for _, arg := range step.proxyBuildArgs(input) {
+		args = append(args, "--build-arg", arg)
+	}
+
+	for _, cacheFrom := range splitLines(input.CacheFrom) {
+		ref, _ := registryCacheRef(cacheFrom)
+		args = append(args, fmt.Sprintf("--cache-from=%s", ref))
+	}
+	if input.CacheFrom != "" {
+		// Embeds the cache metadata into the image, so it can be used as a cache source by later builds
+		args = append(args, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
+	}
+
+	args = append(args, ParseExtraOptions(input.ExtraOptions)...)
+
+	tags := splitLines(input.Tags)
+	for _, tag := range tags {
+		args = append(args, "--tag", tag)
+	}
+
+	args = append(args, []string{"-f", input.File, input.Context}...)
+
+	if err := step.commandCanceler.Err(); err != nil {
+		return err
+	}
+
+	step.logger.Infof("$ DOCKER_BUILDKIT=1 docker %s", strings.Join(args, " "))
+
+	buildCmd := step.commandFactory.Create("docker", args, &command.Opts{
+		Stdout: output,
+		Stderr: output,
+		Env:    []string{"DOCKER_BUILDKIT=1"},
+	})
+	if err := buildCmd.Run(); err != nil {
+		return fmt.Errorf("build docker image with the classic builder: %w", err)
+	}
+
+	if !input.Push {
+		return nil
+	}
+
+	if err := step.pushTags(input, "docker", tags, output); err != nil {
+		return withPhase(PhasePush, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package step
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+var (
+	// ErrAborted is returned when the step was aborted by a signal
+	ErrAborted = errors.New("step aborted")
+	// ErrBuildTimeout is returned when the build did not finish within the build timeout
+	ErrBuildTimeout = errors.New("build timed out")
+)
+
+// cancelGracePeriod is the time commands get to exit after being interrupted, before they are killed.
+const cancelGracePeriod = 10 * time.Second
+
+// CommandCanceler interrupts the commands in flight, so the step can clean up after an abort or timeout.
+type CommandCanceler interface {
+	// CancelRunning interrupts the running commands. Commands created afterwards run normally,
+	// so the builder and temporary files can still be cleaned up.
+	CancelRunning(reason error)
+	// Err returns the reason of the first cancellation, or nil if the commands were never canceled.
+	Err() error
+}
+
+// cancelPollInterval is how often waits between retries check whether the step was canceled.
+const cancelPollInterval = 100 * time.Millisecond
+
+// sleep waits for the duration, returning the cancellation reason as soon as the commands are canceled.
+func (step DockerBuildPushStep) sleep(d time.Duration) error {
+	deadline := time.Now().Add(d)
+	for {
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		time.Sleep(min(remaining, cancelPollInterval))
+	}
+}
+
+// CancelableCommandFactory is a command.Factory whose running commands can be interrupted.
+// The error finder of command.Opts is not supported.
+type CancelableCommandFactory struct {
+	envRepo env.Repository
+
+	mu      sync.Mutex
+	running map[*exec.Cmd]bool
+	reason  error
+}
+
+func NewCancelableCommandFactory(envRepo env.Repository) *CancelableCommandFactory {
+	return &CancelableCommandFactory{
+		envRepo: envRepo,
+		running: map[*exec.Cmd]bool{},
+	}
+}
+
+func (f *CancelableCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	cmd := exec.Command(name, args...)
+	if opts != nil {
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		cmd.Stdin = opts.Stdin
+		// Additional env vars are appended to the env of the current process
+		cmd.Env = append(f.envRepo.List(), opts.Env...)
+		cmd.Dir = opts.Dir
+	}
+	return &cancelableCommand{cmd: cmd, factory: f}
+}
+
+func (f *CancelableCommandFactory) CancelRunning(reason error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reason == nil {
+		f.reason = reason
+	}
+
+	for cmd := range f.running {
+		// Interrupting lets the docker CLI cancel the build on the daemon side too
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			_ = cmd.Process.Kill()
+			continue
+		}
+
+		process := cmd.Process
+		time.AfterFunc(cancelGracePeriod, func() {
+			// Fails harmlessly if the process already exited
+			_ = process.Kill()
+		})
+	}
+}
+
+func (f *CancelableCommandFactory) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reason
+}
+
+func (f *CancelableCommandFactory) start(cmd *exec.Cmd) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	f.running[cmd] = true
+	return nil
+}
+
+func (f *CancelableCommandFactory) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	f.mu.Lock()
+	delete(f.running, cmd)
+	f.mu.Unlock()
+
+	return err
+}
+
+type cancelableCommand struct {
+	cmd     *exec.Cmd
+	factory *CancelableCommandFactory
+}
+
+func (c *cancelableCommand) PrintableCommandArgs() string {
+	var args []string
+	for i, arg := range c.cmd.Args {
+		if i > 0 {
+			arg = fmt.Sprintf("\"%s\"", arg)
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
+func (c *cancelableCommand) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *cancelableCommand) RunAndReturnExitCode() (int, error) {
+	err := c.Run()
+	return c.cmd.ProcessState.ExitCode(), err
+}
+
+func (c *cancelableCommand) RunAndReturnTrimmedOutput() (string, error) {
+	var stdout strings.Builder
+	c.cmd.Stdout = &stdout
+
+	err := c.Run()
+	return strings.TrimSpace(stdout.String()), err
+}
+
+func (c *cancelableCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	var output strings.Builder
+	c.cmd.Stdout = &output
+	c.cmd.Stderr = &output
+
+	err := c.Run()
+	return strings.TrimSpace(output.String()), err
+}
+
+func (c *cancelableCommand) Start() error {
+	if err := c.factory.start(c.cmd); err != nil {
+		return c.wrapError(err)
+	}
+	return nil
+}
+
+func (c *cancelableCommand) Wait() error {
+	if err := c.factory.wait(c.cmd); err != nil {
+		return c.wrapError(err)
+	}
+	return nil
+}
+
+func (c *cancelableCommand) wrapError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("command failed with exit status %d (%s): %w", exitErr.ExitCode(), c.PrintableCommandArgs(), errors.New("check the command's output for details"))
+	}
+	return fmt.Errorf("executing command failed (%s): %w", c.PrintableCommandArgs(), err)
+}
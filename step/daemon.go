@@ -0,0 +1,99 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	daemonWaitInitialDelay = time.Second
+	daemonWaitMaxDelay     = 16 * time.Second
+)
+
+// DockerDaemonInfo is the subset of `docker info` reported by the step.
+type DockerDaemonInfo struct {
+	ServerVersion string
+	StorageDriver string
+	RootDir       string
+}
+
+const dockerDaemonInfoFormat = "{{.ServerVersion}}|{{.Driver}}|{{.DockerRootDir}}"
+
+// waitForDockerDaemon polls the Docker daemon with exponential backoff until it responds or the timeout elapses,
+// as the daemon might still be starting on freshly booted VMs.
+func (step DockerBuildPushStep) waitForDockerDaemon(timeout time.Duration) (DockerDaemonInfo, error) {
+	step.logger.Infof("Waiting for the Docker daemon...")
+
+	deadline := time.Now().Add(timeout)
+	delay := daemonWaitInitialDelay
+	for attempt := 1; ; attempt++ {
+		info, err := step.dockerDaemonInfo()
+		if err == nil {
+			step.logger.Donef("Docker daemon is ready")
+			step.printDockerDaemonInfo(info)
+			step.logger.Println()
+			return info, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return DockerDaemonInfo{}, fmt.Errorf("the Docker daemon is not ready after %s (%d attempts): %w", timeout, attempt, err)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+
+		step.logger.Warnf("Attempt %d: the Docker daemon is not ready, retrying in %s", attempt, delay)
+		step.logger.Debugf("%s", err)
+		if err := step.sleep(delay); err != nil {
+			return DockerDaemonInfo{}, err
+		}
+
+		delay *= 2
+		if delay > daemonWaitMaxDelay {
+			delay = daemonWaitMaxDelay
+		}
+	}
+}
+
+func (step DockerBuildPushStep) dockerDaemonInfo() (DockerDaemonInfo, error) {
+	args := []string{"info", "--format", dockerDaemonInfoFormat}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return DockerDaemonInfo{}, fmt.Errorf("docker info %s: %w", out, err)
+	}
+
+	return ParseDockerDaemonInfo(out)
+}
+
+// ParseDockerDaemonInfo parses the output of `docker info` formatted with dockerDaemonInfoFormat.
+func ParseDockerDaemonInfo(output string) (DockerDaemonInfo, error) {
+	fields := strings.Split(output, "|")
+	if len(fields) != 3 || fields[0] == "" {
+		return DockerDaemonInfo{}, fmt.Errorf("unexpected docker info output: %s", output)
+	}
+
+	return DockerDaemonInfo{
+		ServerVersion: fields[0],
+		StorageDriver: fields[1],
+		RootDir:       fields[2],
+	}, nil
+}
+
+func (step DockerBuildPushStep) printDockerDaemonInfo(info DockerDaemonInfo) {
+	step.logger.Printf("Daemon version: %s", info.ServerVersion)
+	step.logger.Printf("Storage driver: %s", info.StorageDriver)
+
+	// The root dir is only accessible when the daemon runs on this machine (not in a VM or on a remote host)
+	available, err := availableDiskSpace(info.RootDir)
+	if err != nil {
+		step.logger.Printf("Available disk space (%s): unknown", info.RootDir)
+		step.logger.Debugf("%s", err)
+		return
+	}
+	step.logger.Printf("Available disk space (%s): %s", info.RootDir, units.HumanSizeWithPrecision(float64(available), 3))
+}
@@ -0,0 +1,138 @@
+package step
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	prunePolicyNone       = "none"
+	prunePolicyDangling   = "dangling"
+	prunePolicyAggressive = "aggressive"
+)
+
+// DiskSpaceCheck is a path with less free space than required.
+type DiskSpaceCheck struct {
+	Path      string
+	Available uint64
+}
+
+// ensureDiskSpace makes sure the Docker root dir and the local cache folder have enough free space before the build,
+// pruning unused Docker resources according to the policy when they do not.
+func (step DockerBuildPushStep) ensureDiskSpace(input Input, daemon DockerDaemonInfo) error {
+	if input.MinFreeDiskSpace == "" {
+		return nil
+	}
+
+	threshold, err := ParseMinFreeDiskSpace(input.MinFreeDiskSpace)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{daemon.RootDir, filepath.Dir(dockerCacheFolder)}
+
+	insufficient := step.insufficientDiskSpace(paths, threshold)
+	if len(insufficient) == 0 {
+		return nil
+	}
+
+	if input.PrunePolicy == prunePolicyNone {
+		return DiskSpaceError(insufficient, threshold)
+	}
+
+	step.logger.Warnf("Free disk space is below %s, pruning unused Docker resources (%s policy)...", input.MinFreeDiskSpace, input.PrunePolicy)
+	if err := step.pruneDocker(input.PrunePolicy); err != nil {
+		return fmt.Errorf("prune docker resources: %w", err)
+	}
+
+	insufficient = step.insufficientDiskSpace(paths, threshold)
+	if len(insufficient) > 0 {
+		return DiskSpaceError(insufficient, threshold)
+	}
+	step.logger.Donef("Enough disk space is available after pruning")
+	step.logger.Println()
+
+	return nil
+}
+
+func (step DockerBuildPushStep) insufficientDiskSpace(paths []string, threshold uint64) []DiskSpaceCheck {
+	var insufficient []DiskSpaceCheck
+	for _, path := range paths {
+		available, err := availableDiskSpace(path)
+		if err != nil {
+			// The Docker root dir is not accessible when the daemon runs in a VM or on a remote host
+			step.logger.Debugf("Skipping the disk space check of %s: %s", path, err)
+			continue
+		}
+
+		step.logger.Printf("Available disk space (%s): %s", path, units.HumanSizeWithPrecision(float64(available), 3))
+		if available < threshold {
+			insufficient = append(insufficient, DiskSpaceCheck{Path: path, Available: available})
+		}
+	}
+	return insufficient
+}
+
+func (step DockerBuildPushStep) pruneDocker(policy string) error {
+	commands := [][]string{
+		{"container", "prune", "--force"},
+		{"image", "prune", "--force"},
+		{"builder", "prune", "--force"},
+	}
+	if policy == prunePolicyAggressive {
+		commands = [][]string{
+			{"container", "prune", "--force"},
+			{"image", "prune", "--all", "--force"},
+			{"builder", "prune", "--all", "--force"},
+		}
+	}
+
+	for _, args := range commands {
+		// Commands created after a cancellation run normally, so the remaining prunes are skipped explicitly
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+
+		step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+		cmd := step.commandFactory.Create("docker", args, nil)
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return fmt.Errorf("docker %s %s: %w", strings.Join(args, " "), out, err)
+		}
+		step.logger.Printf("%s", out)
+	}
+
+	return nil
+}
+
+// ParseMinFreeDiskSpace parses the min_free_disk_space input, like `10GB` or `512MiB`, into bytes.
+func ParseMinFreeDiskSpace(value string) (uint64, error) {
+	threshold, err := units.FromHumanSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minimum free disk space (%s): %w", value, err)
+	}
+	return uint64(threshold), nil
+}
+
+// DiskSpaceError reports the paths with less free space than the threshold.
+func DiskSpaceError(insufficient []DiskSpaceCheck, threshold uint64) error {
+	var details []string
+	for _, check := range insufficient {
+		details = append(details, fmt.Sprintf("%s has %s free", check.Path, units.HumanSizeWithPrecision(float64(check.Available), 3)))
+	}
+	return fmt.Errorf("not enough disk space for the build, at least %s is required: %s",
+		units.HumanSizeWithPrecision(float64(threshold), 3), strings.Join(details, ", "))
+}
+
+func availableDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
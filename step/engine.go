@@ -0,0 +1,150 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	backendDocker  = "docker"
+	backendPodman  = "podman"
+	backendBuildah = "buildah"
+)
+
+// buildEngine builds (and optionally pushes) the image described by the inputs.
+type buildEngine interface {
+	name() string
+	// validate checks that every input is supported by the engine, before anything is restored or built
+	validate(input Input) error
+	build(input Input, observer buildObserver) error
+}
+
+// buildObserver consumes the output of the build and push commands.
+type buildObserver struct {
+	progress *BuildProgressCounter
+	failures *FailureDetector
+}
+
+func newBuildObserver() buildObserver {
+	return buildObserver{
+		progress: NewBuildProgressCounter(),
+		failures: NewFailureDetector(),
+	}
+}
+
+// output returns the writer of the command output, which is printed to the log and observed at the same time.
+func (o buildObserver) output() io.Writer {
+	return io.MultiWriter(os.Stdout, o.progress, o.failures)
+}
+
+// exportOutput returns the writer of the output of pushing or exporting the built image, which is not counted
+// as build progress, as buildx reports the build steps again.
+func (o buildObserver) exportOutput() io.Writer {
+	return io.MultiWriter(os.Stdout, o.failures)
+}
+
+func (step DockerBuildPushStep) selectBuildEngine(input Input) (buildEngine, error) {
+	switch input.Backend {
+	case backendPodman, backendBuildah:
+		version, err := step.probeOCIEngine(input.Backend)
+		if err != nil {
+			return nil, err
+		}
+		return ociEngine{step: step, binary: input.Backend, version: version}, nil
+	}
+
+	capabilities, err := step.probeCapabilities()
+	if err != nil {
+		return nil, fmt.Errorf("probe docker capabilities: %w", err)
+	}
+
+	if capabilities.buildxAvailable() {
+		return buildxEngine{step: step, capabilities: capabilities}, nil
+	}
+
+	if !input.FallbackToClassicBuilder {
+		return nil, fmt.Errorf("the docker buildx plugin is not available (docker %s), install it or enable fallback_to_classic_builder", capabilities.DockerVersion)
+	}
+	step.logger.Warnf("The docker buildx plugin is not available, falling back to the classic builder")
+
+	return classicEngine{step: step}, nil
+}
+
+type buildxEngine struct {
+	step         DockerBuildPushStep
+	capabilities DockerCapabilities
+}
+
+func (e buildxEngine) name() string {
+	return "docker buildx"
+}
+
+func (e buildxEngine) validate(input Input) error {
+	if input.BuildxHostNetwork {
+		e.step.logger.Warnf("The buildx_host_network input is deprecated, use network=host in driver_opts instead")
+	}
+	if err := ValidateDriverInputs(input); err != nil {
+		return err
+	}
+	if _, _, err := ParseImageArtifact(input, e.step.envRepo.Get("BITRISE_DEPLOY_DIR")); err != nil {
+		return err
+	}
+	if _, err := ParseImageInputs(input.ImageInputs); err != nil {
+		return err
+	}
+	return ValidateFeatureSupport(input, e.capabilities)
+}
+
+func (e buildxEngine) build(input Input, observer buildObserver) error {
+	return e.step.dockerBuild(input, e.capabilities, observer)
+}
+
+type classicEngine struct {
+	step DockerBuildPushStep
+}
+
+func (e classicEngine) name() string {
+	return "classic docker builder"
+}
+
+func (e classicEngine) validate(input Input) error {
+	return unsupportedInputsError(e.name(), ClassicBuilderUnsupportedInputs(input))
+}
+
+func (e classicEngine) build(input Input, observer buildObserver) error {
+	return e.step.classicBuild(input, observer.output())
+}
+
+// builderInputs returns the set inputs which configure the buildx builder,
+// they are not supported by engines without a buildx builder.
+func builderInputs(input Input) []string {
+	var inputs []string
+	check := func(name string, set bool) {
+		if set {
+			inputs = append(inputs, name)
+		}
+	}
+
+	check("builder_name", input.BuilderName != "")
+	check("keep_builder", input.KeepBuilder)
+	check("rootless", input.Rootless)
+	check("driver", input.Driver != driverDockerContainer && input.Driver != driverDocker)
+	check("driver_opts", input.DriverOpts != "")
+	check("buildx_host_network", input.BuildxHostNetwork)
+	check("buildkit_image", input.BuildkitImage != "")
+	check("builder_nodes", input.BuilderNodes != "")
+	check("builder resource limits", len(ResourceDriverOpts(input)) > 0)
+	check("builder_ca_certs", input.BuilderCACerts != "")
+	inputs = append(inputs, buildkitdConfigInputs(input)...)
+
+	return inputs
+}
+
+func unsupportedInputsError(engine string, unsupported []string) error {
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the following inputs are not supported by the %s: %s", engine, strings.Join(unsupported, ", "))
+}
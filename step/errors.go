@@ -0,0 +1,94 @@
+package step
+
+import (
+	"errors"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/bitrise-io/go-utils/v2/exitcode"
+)
+
+const failedPhaseOutputKey = "DOCKER_BUILD_FAILED_PHASE"
+
+// Phase is the part of the step run an error happened in.
+type Phase string
+
+const (
+	PhaseValidation   Phase = "validation"
+	PhaseBuilderSetup Phase = "builder_setup"
+	PhaseCacheRestore Phase = "cache_restore"
+	PhaseBuild        Phase = "build"
+	PhasePush         Phase = "push"
+	PhaseCacheSave    Phase = "cache_save"
+	// PhaseAborted and PhaseTimeout interrupt any other phase, see withPhase
+	PhaseAborted Phase = "aborted"
+	PhaseTimeout Phase = "timeout"
+	PhaseOutputs Phase = "outputs"
+)
+
+// phaseExitCodes are distinct for every phase, so automation can tell the failures apart.
+var phaseExitCodes = map[Phase]exitcode.ExitCode{
+	PhaseValidation:   10,
+	PhaseBuilderSetup: 11,
+	PhaseCacheRestore: 12,
+	PhaseBuild:        13,
+	PhasePush:         14,
+	PhaseCacheSave:    15,
+	PhaseAborted:      16,
+	PhaseTimeout:      17,
+	PhaseOutputs:      18,
+}
+
+// PhaseError classifies an error by the phase it happened in.
+type PhaseError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the phase, or exitcode.Failure for unknown phases.
+func (e *PhaseError) ExitCode() exitcode.ExitCode {
+	if code, ok := phaseExitCodes[e.Phase]; ok {
+		return code
+	}
+	return exitcode.Failure
+}
+
+// withPhase classifies the error, unless it was already classified by a more specific phase.
+// Errors caused by an abort or the build timeout are classified as such, whichever phase they interrupted.
+func withPhase(phase Phase, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var phaseErr *PhaseError
+	if errors.As(err, &phaseErr) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrBuildTimeout):
+		phase = PhaseTimeout
+	case errors.Is(err, ErrAborted):
+		phase = PhaseAborted
+	}
+	return &PhaseError{Phase: phase, Err: err}
+}
+
+func (step DockerBuildPushStep) exportFailedPhase(err error) {
+	var phaseErr *PhaseError
+	if !errors.As(err, &phaseErr) {
+		return
+	}
+
+	exporter := export.NewExporter(step.commandFactory)
+	if exportErr := exporter.ExportOutput(failedPhaseOutputKey, string(phaseErr.Phase)); exportErr != nil {
+		step.logger.Warnf("Failed to export %s: %s", failedPhaseOutputKey, exportErr)
+	}
+}
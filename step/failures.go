@@ -0,0 +1,139 @@
+package step
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+)
+
+const failureCategoryOutputKey = "DOCKER_BUILD_FAILURE_CATEGORY"
+
+const (
+	FailureRateLimit          = "registry_rate_limit"
+	FailureAuthDenied         = "registry_auth_denied"
+	FailureNoSpace            = "no_space_left"
+	FailureUnknownInstruction = "dockerfile_unknown_instruction"
+	FailureMissingPath        = "missing_build_context_path"
+	FailureCacheImport        = "cache_import"
+	FailureOutOfMemory        = "out_of_memory"
+)
+
+type failureSignature struct {
+	category string
+	// patterns are matched case-insensitively against every line of the output
+	patterns []string
+	hint     string
+}
+
+var failureCatalogue = []failureSignature{
+	{
+		category: FailureRateLimit,
+		patterns: []string{"toomanyrequests", "you have reached your pull rate limit"},
+		hint: "The registry rejected the request because of its rate limit. " +
+			"Log in to Docker Hub before the build to get a higher limit, or pull base images through a mirror (see registry_mirrors).",
+	},
+	{
+		category: FailureAuthDenied,
+		patterns: []string{"requested access to the resource is denied", "unauthorized: authentication required", "insufficient_scope", "401 unauthorized", "403 forbidden"},
+		hint: "The registry denied access. Make sure the step runs after logging in to the registry (docker login) " +
+			"and that the credentials have push permission for every repository in tags.",
+	},
+	{
+		category: FailureNoSpace,
+		patterns: []string{"no space left on device"},
+		hint: "The machine ran out of disk space. Set min_free_disk_space and prune_policy to free up space before the build, " +
+			"or reduce the size of the build context with a .dockerignore file.",
+	},
+	{
+		category: FailureUnknownInstruction,
+		patterns: []string{"unknown instruction:"},
+		hint:     "The Dockerfile contains an unknown instruction. Check the instruction on the reported line for typos.",
+	},
+	{
+		category: FailureMissingPath,
+		patterns: []string{"unable to prepare context: path", "failed to calculate checksum of ref", "failed to compute cache key", "failed to read dockerfile"},
+		hint: "A path of the build does not exist. Paths in COPY and ADD are relative to the build context (context input), " +
+			"and might be excluded by .dockerignore. The file input is relative to the working directory.",
+	},
+	{
+		category: FailureCacheImport,
+		patterns: []string{"failed to configure registry cache importer", "failed to import cache", "failed to load cache"},
+		hint: "The build cache could not be imported. Check the cache_from values and the registry credentials, " +
+			"the cache image might not exist yet on the first build.",
+	},
+	{
+		category: FailureOutOfMemory,
+		patterns: []string{"exit code: 137", "signal: killed", "oomkilled"},
+		hint:     "A build process was killed, most likely because it ran out of memory. Increase builder_memory or use a larger machine.",
+	},
+}
+
+// FailureDetector watches the build output for the signatures of known failures.
+type FailureDetector struct {
+	mu       sync.Mutex
+	lines    lineBuffer
+	category string
+}
+
+func NewFailureDetector() *FailureDetector {
+	return &FailureDetector{}
+}
+
+func (d *FailureDetector) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lines.write(p, d.processLine)
+	return len(p), nil
+}
+
+func (d *FailureDetector) processLine(line string) {
+	// The first failure is reported, later ones are usually its consequences
+	if d.category != "" {
+		return
+	}
+
+	line = strings.ToLower(line)
+	for _, signature := range failureCatalogue {
+		for _, pattern := range signature.patterns {
+			if strings.Contains(line, pattern) {
+				d.category = signature.category
+				return
+			}
+		}
+	}
+}
+
+// Category returns the category of the first detected failure, or an empty string if none was detected.
+func (d *FailureDetector) Category() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// An incomplete last line might contain the error as well
+	if line := d.lines.incomplete(); d.category == "" && line != "" {
+		d.processLine(line)
+	}
+	return d.category
+}
+
+// reportFailure prints the remediation hint of the detected failure and exports its category.
+func (step DockerBuildPushStep) reportFailure(detector *FailureDetector) {
+	category := detector.Category()
+	if category == "" {
+		return
+	}
+
+	for _, signature := range failureCatalogue {
+		if signature.category == category {
+			step.logger.Println()
+			step.logger.Warnf("Hint (%s): %s", category, signature.hint)
+			break
+		}
+	}
+
+	exporter := export.NewExporter(step.commandFactory)
+	if err := exporter.ExportOutput(failureCategoryOutputKey, category); err != nil {
+		step.logger.Warnf("Failed to export %s: %s", failureCategoryOutputKey, err)
+	}
+}
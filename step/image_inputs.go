@@ -0,0 +1,192 @@
+package step
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// ImageInput is an image artifact of an earlier build, consumed by the build.
+type ImageInput struct {
+	// Context is the name of the build context the image is exposed as, the image is loaded into the daemon if empty
+	Context string
+	Path    string
+}
+
+// ParseImageInputs parses the image_inputs input, every line is either a path to be loaded into the daemon,
+// or a `name=path` pair exposing the image as the build context `name`.
+func ParseImageInputs(value string) ([]ImageInput, error) {
+	var inputs []ImageInput
+	for _, line := range splitLines(value) {
+		var imageInput ImageInput
+		if context, path, found := strings.Cut(line, "="); found {
+			if context == "" || path == "" {
+				return nil, fmt.Errorf("invalid image input (%s), expected format: path or name=path", line)
+			}
+			imageInput = ImageInput{Context: context, Path: path}
+		} else {
+			imageInput = ImageInput{Path: line}
+		}
+		inputs = append(inputs, imageInput)
+	}
+	return inputs, nil
+}
+
+// imageInputsUnsupportedByEngine returns the image_inputs input if it is set and the engine cannot consume it:
+// build contexts are only supported by buildx, and only engines with a load command can load images.
+func imageInputsUnsupportedByEngine(input Input, canLoad bool) []string {
+	imageInputs, err := ParseImageInputs(input.ImageInputs)
+	if err != nil {
+		// Reported by the engine which consumes the images
+		return []string{"image_inputs"}
+	}
+	for _, imageInput := range imageInputs {
+		if imageInput.Context != "" || !canLoad {
+			return []string{"image_inputs"}
+		}
+	}
+	return nil
+}
+
+// loadImageInputs loads the images without a build context name into the image store of the binary (docker or podman).
+func (step DockerBuildPushStep) loadImageInputs(binary string, imageInputs []ImageInput) error {
+	for _, imageInput := range imageInputs {
+		if imageInput.Context != "" {
+			continue
+		}
+
+		step.logger.Infof("$ %s load --input %s", binary, imageInput.Path)
+		loadCmd := step.commandFactory.Create(binary, []string{"load", "--input", imageInput.Path}, &command.Opts{
+			Stdout: os.Stdout,
+			Stderr: os.Stdout,
+		})
+		if err := loadCmd.Run(); err != nil {
+			return fmt.Errorf("load %s: %w", imageInput.Path, err)
+		}
+	}
+	return nil
+}
+
+// prepareBuildContexts returns the --build-context values exposing the images with a build context name.
+// buildx only accepts OCI layout directories, so tarballs are extracted into tempDir.
+func (step DockerBuildPushStep) prepareBuildContexts(imageInputs []ImageInput, tempDir func() (string, error)) ([]string, error) {
+	var contexts []string
+	for _, imageInput := range imageInputs {
+		if imageInput.Context == "" {
+			continue
+		}
+
+		layout := imageInput.Path
+		info, err := os.Stat(layout)
+		if err != nil {
+			return nil, fmt.Errorf("image input of %s: %w", imageInput.Context, err)
+		}
+		if !info.IsDir() {
+			dir, err := tempDir()
+			if err != nil {
+				return nil, fmt.Errorf("create temp dir: %w", err)
+			}
+			layout = filepath.Join(dir, imageInput.Context)
+			if err := ExtractTar(imageInput.Path, layout); err != nil {
+				return nil, fmt.Errorf("extract %s: %w", imageInput.Path, err)
+			}
+		}
+
+		digest, err := ociLayoutDigest(layout)
+		if err != nil {
+			return nil, fmt.Errorf("image input of %s (%s): %w", imageInput.Context, imageInput.Path, err)
+		}
+
+		step.logger.Printf("Build context %s: %s@%s", imageInput.Context, imageInput.Path, digest)
+		contexts = append(contexts, fmt.Sprintf("%s=oci-layout://%s@%s", imageInput.Context, layout, digest))
+	}
+	return contexts, nil
+}
+
+// ociLayoutDigest returns the digest of the image in the OCI layout,
+// the exported layouts contain a single manifest or index.
+func ociLayoutDigest(layout string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(layout, "index.json"))
+	if os.IsNotExist(err) {
+		return "", errors.New("not an OCI layout (index.json is missing), load the image into the daemon instead")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var index struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return "", fmt.Errorf("parse index.json: %w", err)
+	}
+	if len(index.Manifests) == 0 || index.Manifests[0].Digest == "" {
+		return "", errors.New("the OCI layout contains no image")
+	}
+
+	return index.Manifests[0].Digest, nil
+}
+
+// ExtractTar extracts the directories and regular files of the archive into dest,
+// rejecting entries which would be written outside of it.
+func ExtractTar(archive, dest string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dest, header.Name)
+		// Archives created with `tar -C layout .` contain the ./ entry of dest itself
+		if path != filepath.Clean(dest) && !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(path, reader); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(path string, content io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,29 @@
+package step
+
+import "bytes"
+
+// lineBuffer splits the output written in arbitrary chunks into complete lines.
+// It is not safe for concurrent use, the writers embedding it guard it with their own lock.
+type lineBuffer struct {
+	buffer bytes.Buffer
+}
+
+// write passes every line completed by p to processLine, including its trailing newline.
+func (b *lineBuffer) write(p []byte, processLine func(line string)) {
+	b.buffer.Write(p)
+	for {
+		line, err := b.buffer.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line until the rest of it arrives
+			b.buffer.Reset()
+			b.buffer.WriteString(line)
+			return
+		}
+		processLine(line)
+	}
+}
+
+// incomplete returns the last line which was not terminated by a newline yet.
+func (b *lineBuffer) incomplete() string {
+	return b.buffer.String()
+}
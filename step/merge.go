@@ -0,0 +1,141 @@
+package step
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const modeMergeManifests = "merge-manifests"
+
+// ManifestSource is a per-platform image merged into the index of merge-manifests mode.
+type ManifestSource struct {
+	Ref       string
+	Platforms []string
+}
+
+// ParseManifestSources returns the image references of the manifest_sources input and the manifest_sources_file,
+// one per line. Bare digests refer to the repository of the first tag.
+func ParseManifestSources(value, fileContent, firstTag string) []string {
+	var refs []string
+	for _, line := range append(splitLines(value), splitLines(fileContent)...) {
+		if strings.HasPrefix(line, "sha256:") {
+			line = DigestReference(firstTag, line)
+		}
+		refs = append(refs, line)
+	}
+	return refs
+}
+
+// ValidateManifestSources checks that every source has a known platform, and that no platform is provided by
+// more than one source, as the resulting index could only contain one of them.
+func ValidateManifestSources(sources []ManifestSource) error {
+	owners := map[string]string{}
+	var errs []string
+	for _, source := range sources {
+		if len(source.Platforms) == 0 {
+			errs = append(errs, fmt.Sprintf("the platform of %s is unknown", source.Ref))
+			continue
+		}
+		for _, platform := range source.Platforms {
+			if owner, ok := owners[platform]; ok {
+				errs = append(errs, fmt.Sprintf("%s is provided by both %s and %s", platform, owner, source.Ref))
+				continue
+			}
+			owners[platform] = source.Ref
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid manifest sources: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// mergeManifests creates a multi-platform index of the per-platform images under the tags.
+func (step DockerBuildPushStep) mergeManifests(input Input) error {
+	var fileContent string
+	if input.ManifestSourcesFile != "" {
+		content, err := os.ReadFile(input.ManifestSourcesFile)
+		if err != nil {
+			return withPhase(PhaseValidation, fmt.Errorf("read manifest sources file: %w", err))
+		}
+		fileContent = string(content)
+	}
+
+	tags := splitLines(input.Tags)
+	refs := ParseManifestSources(input.ManifestSources, fileContent, tags[0])
+	if len(refs) == 0 {
+		return withPhase(PhaseValidation, errors.New("manifest_sources or manifest_sources_file is required by mode merge-manifests"))
+	}
+
+	step.logger.Infof("Merging %d images...", len(refs))
+
+	var sources []ManifestSource
+	var platforms []string
+	for _, ref := range refs {
+		sourcePlatforms, err := step.imagePlatforms(ref)
+		if err != nil {
+			return withPhase(PhaseValidation, fmt.Errorf("resolve %s: %w", ref, err))
+		}
+		step.logger.Printf("%s: %s", ref, formatPlatforms(sourcePlatforms))
+
+		sources = append(sources, ManifestSource{Ref: ref, Platforms: sourcePlatforms})
+		platforms = append(platforms, sourcePlatforms...)
+	}
+	if err := ValidateManifestSources(sources); err != nil {
+		return withPhase(PhaseValidation, err)
+	}
+	sort.Strings(platforms)
+
+	if err := step.createTags(input, tags, refs...); err != nil {
+		return withPhase(PhasePush, fmt.Errorf("create index: %w", err))
+	}
+
+	index, err := step.inspectPushedTag(tags[0])
+	if err != nil {
+		return withPhase(PhasePush, fmt.Errorf("resolve the created index: %w", err))
+	}
+
+	return step.finishRegistryMode(input, tags, index.Digest, platforms)
+}
+
+// imagePlatforms returns the platforms of the image, which is either an index or a single image manifest.
+func (step DockerBuildPushStep) imagePlatforms(ref string) ([]string, error) {
+	manifest, err := step.inspectPushedTag(ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) > 0 {
+		return manifest.Platforms(), nil
+	}
+
+	// The platform of a single image manifest is stored in the image config
+	args := []string{"buildx", "imagetools", "inspect", "--format", "{{json .Image}}", ref}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", out, err)
+	}
+
+	var config struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	}
+	if err := json.Unmarshal([]byte(out), &config); err != nil {
+		return nil, fmt.Errorf("parse image config: %w", err)
+	}
+	if config.OS == "" {
+		return nil, nil
+	}
+
+	platform := config.OS + "/" + config.Architecture
+	if config.Variant != "" {
+		platform += "/" + config.Variant
+	}
+	return []string{platform}, nil
+}
@@ -0,0 +1,187 @@
+package step
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/docker/go-units"
+)
+
+const (
+	cacheRestoreDurationOutputKey = "DOCKER_CACHE_RESTORE_DURATION"
+	cacheSaveDurationOutputKey    = "DOCKER_CACHE_SAVE_DURATION"
+	cacheSizeBeforeOutputKey      = "DOCKER_CACHE_SIZE_BEFORE"
+	cacheSizeAfterOutputKey       = "DOCKER_CACHE_SIZE_AFTER"
+	cachedStepsOutputKey          = "DOCKER_BUILD_CACHED_STEPS"
+	executedStepsOutputKey        = "DOCKER_BUILD_EXECUTED_STEPS"
+)
+
+type buildMetrics struct {
+	cacheRestoreDuration time.Duration
+	cacheSaveDuration    time.Duration
+	cacheSizeBefore      int64
+	cacheSizeAfter       int64
+	cachedSteps          int
+	executedSteps        int
+}
+
+func (step DockerBuildPushStep) exportMetrics(metrics buildMetrics) error {
+	exporter := export.NewExporter(step.commandFactory)
+
+	outputs := map[string]string{
+		cacheRestoreDurationOutputKey: fmt.Sprintf("%.3f", metrics.cacheRestoreDuration.Seconds()),
+		cacheSaveDurationOutputKey:    fmt.Sprintf("%.3f", metrics.cacheSaveDuration.Seconds()),
+		cacheSizeBeforeOutputKey:      fmt.Sprintf("%d", metrics.cacheSizeBefore),
+		cacheSizeAfterOutputKey:       fmt.Sprintf("%d", metrics.cacheSizeAfter),
+		cachedStepsOutputKey:          fmt.Sprintf("%d", metrics.cachedSteps),
+		executedStepsOutputKey:        fmt.Sprintf("%d", metrics.executedSteps),
+	}
+
+	for key, value := range outputs {
+		if err := exporter.ExportOutput(key, value); err != nil {
+			return fmt.Errorf("export %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (step DockerBuildPushStep) printMetrics(metrics buildMetrics) {
+	step.logger.Println()
+	step.logger.Infof("Build summary")
+
+	rows := [][2]string{
+		{"Cache restore duration", metrics.cacheRestoreDuration.Round(time.Millisecond).String()},
+		{"Cache save duration", metrics.cacheSaveDuration.Round(time.Millisecond).String()},
+		{"Cache size before build", units.HumanSizeWithPrecision(float64(metrics.cacheSizeBefore), 3)},
+		{"Cache size after build", units.HumanSizeWithPrecision(float64(metrics.cacheSizeAfter), 3)},
+		{"Cached build steps", fmt.Sprintf("%d", metrics.cachedSteps)},
+		{"Executed build steps", fmt.Sprintf("%d", metrics.executedSteps)},
+	}
+
+	for _, row := range rows {
+		step.logger.Printf("| %-24s | %12s |", row[0], row[1])
+	}
+}
+
+func directorySize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	return size, err
+}
+
+// Matches build step vertices of the plain progress output, for example `#5 [2/3] RUN apk add curl`
+// or `#7 [builder linux/arm64 1/4] FROM docker.io/library/alpine`, while skipping internal vertices.
+var buildStepVertexPattern = regexp.MustCompile(`^#(\d+) \[(?:[^\]]*\s)?\d+/\d+\]`)
+var vertexStatusPattern = regexp.MustCompile(`^#(\d+) (CACHED|DONE)\b`)
+
+// Matches the instructions of the Podman and Buildah output, for example `STEP 2/3: RUN apk add curl`
+// or `[2/2] STEP 1/4: FROM alpine AS final`, their results are reported as `--> Using cache <id>` or `--> <id>`.
+var ociStepPattern = regexp.MustCompile(`^(?:\[\d+/\d+\] )?STEP \d+/\d+: (\S+)`)
+
+// BuildProgressCounter consumes the plain progress output of buildx (or the output of Podman and Buildah) and counts
+// the build steps which were served from cache and the ones which were executed.
+type BuildProgressCounter struct {
+	mu       sync.Mutex
+	lines    lineBuffer
+	steps    map[string]bool
+	cached   map[string]bool
+	executed map[string]bool
+	// ociStep is the key of the Podman or Buildah step in progress, as their output has no vertex IDs
+	ociStep    string
+	ociStepNum int
+}
+
+func NewBuildProgressCounter() *BuildProgressCounter {
+	return &BuildProgressCounter{
+		steps:    map[string]bool{},
+		cached:   map[string]bool{},
+		executed: map[string]bool{},
+	}
+}
+
+func (c *BuildProgressCounter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lines.write(p, c.processLine)
+	return len(p), nil
+}
+
+func (c *BuildProgressCounter) processLine(line string) {
+	if match := ociStepPattern.FindStringSubmatch(line); match != nil {
+		c.ociStep = ""
+		// FROM instructions are not reported as cached or executed
+		if match[1] != "FROM" {
+			c.ociStepNum++
+			c.ociStep = fmt.Sprintf("oci-%d", c.ociStepNum)
+			c.steps[c.ociStep] = true
+		}
+		return
+	}
+
+	if c.ociStep != "" && strings.HasPrefix(line, "--> ") {
+		if strings.HasPrefix(line, "--> Using cache") {
+			c.cached[c.ociStep] = true
+		} else {
+			c.executed[c.ociStep] = true
+		}
+		return
+	}
+
+	if match := buildStepVertexPattern.FindStringSubmatch(line); match != nil {
+		c.steps[match[1]] = true
+		return
+	}
+
+	if match := vertexStatusPattern.FindStringSubmatch(line); match != nil {
+		switch match[2] {
+		case "CACHED":
+			c.cached[match[1]] = true
+		case "DONE":
+			c.executed[match[1]] = true
+		}
+	}
+}
+
+// Counts returns the number of cached and executed build steps seen so far.
+func (c *BuildProgressCounter) Counts() (cached int, executed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for vertex := range c.steps {
+		switch {
+		case c.cached[vertex]:
+			cached++
+		case c.executed[vertex]:
+			executed++
+		}
+	}
+
+	return cached, executed
+}
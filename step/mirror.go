@@ -0,0 +1,109 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// MirrorTags returns the tags of the image in the mirror registry: the registry of every tag
+// is replaced by the mirror, the repository path and the tag are kept.
+func MirrorTags(tags []string, mirror string) []string {
+	mirror = strings.TrimSuffix(mirror, "/")
+
+	var mirrorTags []string
+	for _, tag := range tags {
+		_, path := splitRegistry(tag)
+		mirrorTags = append(mirrorTags, mirror+"/"+path)
+	}
+	return mirrorTags
+}
+
+// splitRegistry splits an image reference into its registry and repository path,
+// references without a registry like `team/app:1.0` are Docker Hub references.
+func splitRegistry(ref string) (string, string) {
+	first, rest, found := strings.Cut(ref, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		if !found {
+			ref = "library/" + ref
+		}
+		return "docker.io", ref
+	}
+	return first, rest
+}
+
+// imageRepository returns the reference without its tag or digest.
+func imageRepository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// DigestReference returns the reference pinning the digest in the repository of ref, for example
+// `myregistry.com:5000/app@sha256:...` for `myregistry.com:5000/app:1.0`.
+func DigestReference(ref, digest string) string {
+	return imageRepository(ref) + "@" + digest
+}
+
+// mirrorImage copies the pushed image by digest, with all of its platforms, to every mirror registry.
+// Every registry is retried independently, and all of them are attempted before reporting the failures.
+func (step DockerBuildPushStep) mirrorImage(input Input, digest string, platforms []string, output io.Writer) error {
+	mirrors := splitLines(input.MirrorRegistries)
+	tags := splitLines(input.Tags)
+	source := DigestReference(tags[0], digest)
+
+	step.logger.Println()
+	step.logger.Infof("Mirroring %s to %d registries...", source, len(mirrors))
+
+	failures := map[string]error{}
+	for _, mirror := range mirrors {
+		mirrorTags := MirrorTags(tags, mirror)
+
+		args := []string{"buildx", "imagetools", "create"}
+		for _, tag := range mirrorTags {
+			args = append(args, "--tag", tag)
+		}
+		args = append(args, source)
+
+		err := step.pushWithRetry(input, mirror, output, func(output io.Writer) error {
+			step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+			copyCmd := step.commandFactory.Create("docker", args, &command.Opts{
+				Stdout: output,
+				Stderr: output,
+			})
+			return copyCmd.Run()
+		})
+		if err == nil && input.VerifyPush {
+			err = step.verifyPushedTags(mirrorTags, digest, platforms)
+		}
+		if err != nil {
+			failures[mirror] = err
+		}
+	}
+
+	step.logger.Println()
+	step.logger.Infof("Mirroring summary")
+	for _, mirror := range mirrors {
+		if err := failures[mirror]; err != nil {
+			step.logger.Errorf("%s: failed: %s", mirror, err)
+		} else {
+			step.logger.Donef("%s: mirrored", mirror)
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("mirroring to %d of %d registries failed", len(failures), len(mirrors))
+	if input.AllowMirrorFailures {
+		step.logger.Warnf("%s, ignoring the failures as allow_mirror_failures is set", err)
+		return nil
+	}
+	return err
+}
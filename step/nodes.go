@@ -0,0 +1,181 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+)
+
+type builderNode struct {
+	endpoint  string
+	platforms []string
+}
+
+// BuilderNodeStatus is a node of a builder as reported by `docker buildx inspect`.
+type BuilderNodeStatus struct {
+	Name     string
+	Endpoint string
+	Status   string
+	// Buildkit is the version of the BuildKit daemon, it is only reported for running nodes
+	Buildkit  string
+	Platforms []string
+}
+
+func parseBuilderNodes(value string) ([]builderNode, error) {
+	var nodes []builderNode
+	for _, line := range splitLines(value) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid builder node (%s), expected format: endpoint platform1,platform2", line)
+		}
+
+		var platforms []string
+		for _, platform := range strings.Split(fields[1], ",") {
+			if platform = strings.TrimSpace(platform); platform != "" {
+				platforms = append(platforms, platform)
+			}
+		}
+		if len(platforms) == 0 {
+			return nil, fmt.Errorf("no platform specified for builder node %s", fields[0])
+		}
+
+		nodes = append(nodes, builderNode{endpoint: fields[0], platforms: platforms})
+	}
+
+	return nodes, nil
+}
+
+// appendBuilderNodes adds the nodes to the builder, so that the build of each platform is dispatched
+// to the node natively supporting it instead of emulating it on the first node.
+// The nodes get the same driver options and BuildKit daemon configuration as the first node.
+func (step DockerBuildPushStep) appendBuilderNodes(input Input, builderName string, nodes []builderNode, nodeArgs []string) error {
+	for i, node := range nodes {
+		args := []string{
+			"buildx", "create", "--append",
+			"--name", builderName,
+			"--node", fmt.Sprintf("%s-node%d", builderName, i+1),
+			"--driver", input.Driver,
+			"--platform", strings.Join(node.platforms, ","),
+		}
+		args = append(args, nodeArgs...)
+		args = append(args, node.endpoint)
+
+		step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+		cmd := step.commandFactory.Create("docker", args, nil)
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return fmt.Errorf("append node %s to buildx instance %s: %w", node.endpoint, out, err)
+		}
+	}
+
+	return nil
+}
+
+// checkBuilderHealth boots every node of the builder and makes sure all of them are reachable before starting the build.
+func (step DockerBuildPushStep) checkBuilderHealth(name string) error {
+	step.logger.Printf("Checking the nodes of buildx instance %s...", name)
+
+	args := []string{
+		"buildx", "inspect", "--bootstrap", name,
+	}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("connect to buildx instance %s %s: %w", name, out, err)
+	}
+	step.logger.Debugf("%s", out)
+
+	var unhealthy []string
+	for _, node := range ParseBuilderNodeStatuses(out) {
+		if node.Status == "running" {
+			step.logger.Printf("- %s (%s): %s [%s]", node.Name, node.Endpoint, node.Status, strings.Join(node.Platforms, ", "))
+			continue
+		}
+		step.logger.Errorf("- %s (%s): %s", node.Name, node.Endpoint, node.Status)
+		unhealthy = append(unhealthy, node.Name)
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("unhealthy builder nodes: %s", strings.Join(unhealthy, ", "))
+	}
+
+	return nil
+}
+
+// ParseBuilderNodeStatuses parses the Nodes section of the `docker buildx inspect` output.
+func ParseBuilderNodeStatuses(output string) []BuilderNodeStatus {
+	var nodes []BuilderNodeStatus
+	inNodes := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "Nodes:" {
+			inNodes = true
+			continue
+		}
+		if !inNodes {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		if key == "Name" {
+			nodes = append(nodes, BuilderNodeStatus{Name: value})
+			continue
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+
+		node := &nodes[len(nodes)-1]
+		switch key {
+		case "Endpoint":
+			node.Endpoint = value
+		case "Status":
+			node.Status = value
+		case "Buildkit", "BuildKit version":
+			node.Buildkit = value
+		case "Platforms":
+			for _, platform := range strings.Split(value, ",") {
+				if platform = strings.TrimSpace(platform); platform != "" {
+					node.Platforms = append(node.Platforms, platform)
+				}
+			}
+		}
+	}
+
+	return nodes
+}
+
+// BuildkitVersion returns the oldest BuildKit version of the nodes, as every node has to support the used features.
+// It is empty when none of the nodes reported a parsable version.
+func BuildkitVersion(nodes []BuilderNodeStatus) string {
+	var oldest string
+	var oldestVersion Version
+	for _, node := range nodes {
+		version, ok := ParseVersion(node.Buildkit)
+		if !ok {
+			continue
+		}
+		if oldest == "" || !version.AtLeast(oldestVersion) {
+			oldest = node.Buildkit
+			oldestVersion = version
+		}
+	}
+	return oldest
+}
+
+// probeBuildkitVersion boots the builder and detects the version of its BuildKit daemons.
+func (step DockerBuildPushStep) probeBuildkitVersion(name string) (string, error) {
+	args := []string{
+		"buildx", "inspect", "--bootstrap", name,
+	}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("inspect buildx instance %s %s: %w", name, out, err)
+	}
+	return BuildkitVersion(ParseBuilderNodeStatuses(out)), nil
+}
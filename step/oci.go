@@ -0,0 +1,161 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// ociEngine builds images with Podman or Buildah, for runners without a Docker daemon (for example rootless Podman).
+type ociEngine struct {
+	step    DockerBuildPushStep
+	binary  string
+	version string
+}
+
+func (step DockerBuildPushStep) probeOCIEngine(binary string) (string, error) {
+	cmd := step.commandFactory.Create(binary, []string{"version"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s is not available: %s: %w", binary, out, err)
+	}
+
+	version := parseOCIEngineVersion(out)
+	step.logger.Printf("%s version: %s", binary, version)
+	return version, nil
+}
+
+// parseOCIEngineVersion returns the version of the `podman version` (`Version: 4.9.3`)
+// or `buildah version` (`Version:         1.33.7`) output.
+func parseOCIEngineVersion(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if found && strings.TrimSpace(key) == "Version" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return "unknown"
+}
+
+func (e ociEngine) name() string {
+	return fmt.Sprintf("%s backend", e.binary)
+}
+
+func (e ociEngine) validate(input Input) error {
+	return unsupportedInputsError(e.name(), OCIEngineUnsupportedInputs(input))
+}
+
+// OCIEngineUnsupportedInputs returns the inputs which cannot be translated to a Podman or Buildah build.
+// Only registry cache sources and destinations are supported, as these engines cannot export the cache locally.
+func OCIEngineUnsupportedInputs(input Input) []string {
+	var unsupported []string
+	if input.UseBitriseCache {
+		unsupported = append(unsupported, "use_bitrise_cache")
+	}
+	for _, cacheFrom := range splitLines(input.CacheFrom) {
+		if _, ok := OCICacheRepository(cacheFrom); !ok {
+			unsupported = append(unsupported, "cache_from")
+			break
+		}
+	}
+	for _, cacheTo := range splitLines(input.CacheTo) {
+		if _, ok := OCICacheRepository(cacheTo); !ok {
+			unsupported = append(unsupported, "cache_to")
+			break
+		}
+	}
+	if input.PropagateProxy {
+		unsupported = append(unsupported, "propagate_proxy")
+	}
+	if input.OutputType != "" && input.OutputType != outputTypeNone {
+		unsupported = append(unsupported, "output_type")
+	}
+	if input.VerifyPush {
+		unsupported = append(unsupported, "verify_push")
+	}
+	if input.MirrorRegistries != "" {
+		unsupported = append(unsupported, "mirror_registries")
+	}
+	// Only Podman can load images, named build contexts are not supported
+	unsupported = append(unsupported, imageInputsUnsupportedByEngine(input, input.Backend == backendPodman)...)
+
+	return append(unsupported, builderInputs(input)...)
+}
+
+func (e ociEngine) build(input Input, observer buildObserver) error {
+	step := e.step
+	step.logger.Infof("Building image with %s...", e.binary)
+
+	imageInputs, err := ParseImageInputs(input.ImageInputs)
+	if err != nil {
+		return err
+	}
+	if err := step.loadImageInputs(e.binary, imageInputs); err != nil {
+		return fmt.Errorf("load image inputs: %w", err)
+	}
+
+	args := []string{
+		"build",
+		// Layer caching is disabled by default for Buildah
+		"--layers",
+	}
+
+	for _, arg := range splitLines(input.BuildArg) {
+		args = append(args, "--build-arg", arg)
+	}
+
+	for _, cacheFrom := range splitLines(input.CacheFrom) {
+		repository, _ := OCICacheRepository(cacheFrom)
+		args = append(args, fmt.Sprintf("--cache-from=%s", repository))
+	}
+	for _, cacheTo := range splitLines(input.CacheTo) {
+		repository, _ := OCICacheRepository(cacheTo)
+		args = append(args, fmt.Sprintf("--cache-to=%s", repository))
+	}
+
+	args = append(args, ParseExtraOptions(input.ExtraOptions)...)
+
+	tags := splitLines(input.Tags)
+	for _, tag := range tags {
+		args = append(args, "--tag", tag)
+	}
+
+	args = append(args, []string{"-f", input.File, input.Context}...)
+
+	if err := step.commandCanceler.Err(); err != nil {
+		return err
+	}
+
+	step.logger.Infof("$ %s %s", e.binary, strings.Join(args, " "))
+
+	output := observer.output()
+	buildCmd := step.commandFactory.Create(e.binary, args, &command.Opts{
+		Stdout: output,
+		Stderr: output,
+	})
+	if err := buildCmd.Run(); err != nil {
+		return fmt.Errorf("build image with %s: %w", e.binary, err)
+	}
+
+	if !input.Push {
+		return nil
+	}
+
+	if err := step.pushTags(input, e.binary, tags, output); err != nil {
+		return withPhase(PhasePush, err)
+	}
+
+	return nil
+}
+
+// OCICacheRepository translates a buildx registry cache source or destination to the repository accepted by
+// the --cache-from and --cache-to flags of Podman and Buildah. They tag the cache images themselves,
+// so the tag or digest of the reference, like `repo:cache`, is dropped.
+func OCICacheRepository(cache string) (string, bool) {
+	ref, ok := registryCacheRef(cache)
+	if !ok {
+		return "", false
+	}
+	return imageRepository(ref), true
+}
@@ -0,0 +1,106 @@
+package step
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const (
+	modeBuild   = "build"
+	modePromote = "promote"
+)
+
+// runRegistryMode runs the modes which create tags from images already in the registry with
+// `docker buildx imagetools`, without building anything.
+func (step DockerBuildPushStep) runRegistryMode(input Input) error {
+	if input.Backend != backendDocker {
+		return withPhase(PhaseValidation, fmt.Errorf("mode %s is not supported by the %s backend", input.Mode, input.Backend))
+	}
+
+	// imagetools talks to the registries directly, so neither the Docker daemon nor a builder is needed
+	buildxVersion, err := step.probeBuildxVersion()
+	if err != nil {
+		return withPhase(PhaseBuilderSetup, fmt.Errorf("mode %s requires the docker buildx plugin: %w", input.Mode, err))
+	}
+	step.logger.Printf("Buildx version: %s", buildxVersion)
+	step.logger.Println()
+
+	switch input.Mode {
+	case modePromote:
+		return step.promote(input)
+	case modeMergeManifests:
+		return step.mergeManifests(input)
+	default:
+		return withPhase(PhaseValidation, fmt.Errorf("unknown mode: %s", input.Mode))
+	}
+}
+
+// promote creates the tags from the source image. The whole index is copied,
+// so every platform and the attestations of the source image are preserved.
+func (step DockerBuildPushStep) promote(input Input) error {
+	if input.SourceImage == "" {
+		return withPhase(PhaseValidation, errors.New("source_image is required by mode promote"))
+	}
+
+	// Resolving the digest first makes sure every tag points to the same image, even if the source tag moves meanwhile
+	source, err := step.inspectPushedTag(input.SourceImage)
+	if err != nil {
+		return withPhase(PhaseValidation, fmt.Errorf("resolve source image %s: %w", input.SourceImage, err))
+	}
+	sourceRef := DigestReference(input.SourceImage, source.Digest)
+
+	step.logger.Infof("Promoting %s...", input.SourceImage)
+	step.logger.Printf("Digest: %s", source.Digest)
+	if platforms := source.Platforms(); len(platforms) > 0 {
+		step.logger.Printf("Platforms: %s", strings.Join(platforms, ", "))
+	}
+
+	tags := splitLines(input.Tags)
+	if err := step.createTags(input, tags, sourceRef); err != nil {
+		return withPhase(PhasePush, fmt.Errorf("promote image: %w", err))
+	}
+
+	return step.finishRegistryMode(input, tags, source.Digest, source.Platforms())
+}
+
+// createTags points the tags to the source reference, copying the image between repositories if needed.
+func (step DockerBuildPushStep) createTags(input Input, tags []string, sources ...string) error {
+	args := []string{"buildx", "imagetools", "create"}
+	for _, tag := range tags {
+		args = append(args, "--tag", tag)
+	}
+	args = append(args, sources...)
+
+	return step.pushWithRetry(input, tags[0], os.Stdout, func(output io.Writer) error {
+		step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+		createCmd := step.commandFactory.Create("docker", args, &command.Opts{
+			Stdout: output,
+			Stderr: output,
+		})
+		return createCmd.Run()
+	})
+}
+
+// finishRegistryMode exports the digest of the created tags, then verifies and mirrors them like a pushed image.
+func (step DockerBuildPushStep) finishRegistryMode(input Input, tags []string, digest string, platforms []string) error {
+	if err := step.exportImageDigest(digest); err != nil {
+		return err
+	}
+	if input.VerifyPush {
+		if err := step.verifyPushedTags(tags, digest, platforms); err != nil {
+			return withPhase(PhasePush, fmt.Errorf("verify tags: %w", err))
+		}
+	}
+	if input.MirrorRegistries != "" {
+		if err := step.mirrorImage(input, digest, platforms, os.Stdout); err != nil {
+			return withPhase(PhasePush, fmt.Errorf("mirror image: %w", err))
+		}
+	}
+	return nil
+}
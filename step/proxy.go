@@ -0,0 +1,161 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// proxyEnvKeys are the proxy related env vars which are predefined build args of Docker,
+// so they can be forwarded to the build without declaring them with ARG in the Dockerfile.
+var proxyEnvKeys = []string{
+	"HTTP_PROXY", "http_proxy",
+	"HTTPS_PROXY", "https_proxy",
+	"FTP_PROXY", "ftp_proxy",
+	"NO_PROXY", "no_proxy",
+	"ALL_PROXY", "all_proxy",
+}
+
+// builderCABundlePath is the system CA bundle of the BuildKit image, the custom CAs are appended to it.
+const builderCABundlePath = "/etc/ssl/certs/ca-certificates.crt"
+
+// ProxyEnvs returns the proxy env vars which are set, in KEY=value format.
+func ProxyEnvs(getenv func(string) string) []string {
+	var envs []string
+	for _, key := range proxyEnvKeys {
+		if value := getenv(key); value != "" {
+			envs = append(envs, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return envs
+}
+
+// ProxyDriverOpts returns the --driver-opt values setting the proxy env vars in the BuildKit container.
+func ProxyDriverOpts(input Input, getenv func(string) string) []string {
+	if !input.PropagateProxy || input.Driver != driverDockerContainer {
+		return nil
+	}
+
+	var opts []string
+	for _, env := range ProxyEnvs(getenv) {
+		opts = append(opts, csvField("env."+env))
+	}
+	return opts
+}
+
+// csvField quotes the value if needed, as buildx parses every --driver-opt value as CSV,
+// and would split values like NO_PROXY=localhost,127.0.0.1 at the comma.
+func csvField(value string) string {
+	if !strings.ContainsAny(value, ",\"\n") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// proxyBuildArgs returns the proxy build args which are not already set by the build_arg input.
+func (step DockerBuildPushStep) proxyBuildArgs(input Input) []string {
+	if !input.PropagateProxy {
+		return nil
+	}
+
+	userArgs := map[string]bool{}
+	for _, arg := range splitLines(input.BuildArg) {
+		key, _, _ := strings.Cut(arg, "=")
+		userArgs[key] = true
+	}
+
+	var args []string
+	for _, env := range ProxyEnvs(step.envRepo.Get) {
+		key, _, _ := strings.Cut(env, "=")
+		if !userArgs[key] {
+			args = append(args, env)
+		}
+	}
+	return args
+}
+
+// builderCACerts returns the CA bundles to be installed into the builder: the ones configured by the input
+// and the bundle referenced by SSL_CERT_FILE, which is commonly set on machines behind a TLS intercepting proxy.
+func (step DockerBuildPushStep) builderCACerts(input Input) ([]string, error) {
+	if !input.PropagateProxy {
+		return nil, nil
+	}
+
+	paths := splitLines(input.BuilderCACerts)
+	if certFile := step.envRepo.Get("SSL_CERT_FILE"); certFile != "" {
+		paths = append(paths, certFile)
+	}
+
+	var absPaths []string
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("resolve CA bundle path %s: %w", path, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		absPaths = append(absPaths, absPath)
+	}
+
+	return absPaths, nil
+}
+
+// installBuilderCACerts appends the CA bundles to the system CA bundle of the BuildKit container
+// and restarts it, so the daemon trusts them when pulling images and fetching remote sources.
+func (step DockerBuildPushStep) installBuilderCACerts(builder buildxBuilder, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// The container is only created when the builder is booted
+	bootstrapCmd := step.commandFactory.Create("docker", []string{"buildx", "inspect", "--bootstrap", builder.name}, nil)
+	if out, err := bootstrapCmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("boot buildx instance %s: %w", out, err)
+	}
+
+	container := builder.buildkitContainer()
+	for _, path := range paths {
+		step.logger.Printf("Installing CA bundle %s into %s", path, container)
+
+		if err := step.appendToContainerFile(container, path, builderCABundlePath); err != nil {
+			return err
+		}
+	}
+
+	restartCmd := step.commandFactory.Create("docker", []string{"restart", container}, nil)
+	if out, err := restartCmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("restart %s: %s: %w", container, out, err)
+	}
+
+	return nil
+}
+
+func (step DockerBuildPushStep) appendToContainerFile(container, source, destination string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", source, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			step.logger.Warnf("Failed to close %s: %s", source, err)
+		}
+	}()
+
+	// Running as root, as the daemon of rootless images runs as an unprivileged user
+	args := []string{"exec", "--interactive", "--user", "0", container, "sh", "-c", fmt.Sprintf("cat >> %s", destination)}
+	var output strings.Builder
+	cmd := step.commandFactory.Create("docker", args, &command.Opts{
+		Stdin:  file,
+		Stdout: &output,
+		Stderr: &output,
+	})
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("append %s to %s in %s: %s: %w", source, destination, container, output.String(), err)
+	}
+
+	return nil
+}
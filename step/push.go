@@ -0,0 +1,128 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const (
+	pushRetryInitialDelay = 2 * time.Second
+	pushRetryMaxDelay     = 30 * time.Second
+	// pushOutputTailSize is the amount of the push output kept to tell transient failures apart,
+	// the error is printed at the end of the output.
+	pushOutputTailSize = 8 * 1024
+)
+
+// Registry and network errors which usually go away on their own
+var transientPushErrorPatterns = []string{
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"connection reset by peer",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"tls handshake timeout",
+	"unexpected eof",
+	"server misbehaving",
+}
+
+// pushWithRetry runs the push, retrying it with exponential backoff as long as it fails with a transient error
+// and the retry budget of the push_retries input is not exhausted.
+func (step DockerBuildPushStep) pushWithRetry(input Input, image string, output io.Writer, push func(output io.Writer) error) error {
+	delay := pushRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		tail := &tailBuffer{size: pushOutputTailSize}
+		err := push(io.MultiWriter(output, tail))
+		if err == nil {
+			if attempt > 1 {
+				step.logger.Donef("Pushed %s in attempt %d", image, attempt)
+			}
+			return nil
+		}
+
+		// Retrying makes no sense once the step is aborted or timed out
+		if step.commandCanceler.Err() != nil {
+			return err
+		}
+		if !IsTransientPushError(tail.String()) {
+			return err
+		}
+		if attempt > input.PushRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		step.logger.Warnf("Attempt %d: pushing %s failed with a transient error, retrying in %s", attempt, image, delay)
+		if err := step.sleep(delay); err != nil {
+			return err
+		}
+
+		delay *= 2
+		if delay > pushRetryMaxDelay {
+			delay = pushRetryMaxDelay
+		}
+	}
+}
+
+// IsTransientPushError tells whether the output of a failed push reports an error which might go away on retry.
+func IsTransientPushError(output string) bool {
+	output = strings.ToLower(output)
+	for _, pattern := range transientPushErrorPatterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailBuffer keeps the last size bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > b.size {
+		b.data = b.data[len(b.data)-b.size:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// pushTags pushes the locally built tags one by one with the push command of the binary, retrying transient failures.
+func (step DockerBuildPushStep) pushTags(input Input, binary string, tags []string, output io.Writer) error {
+	for _, tag := range tags {
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		err := step.pushWithRetry(input, tag, output, func(output io.Writer) error {
+			step.logger.Infof("$ %s push %s", binary, tag)
+
+			pushCmd := step.commandFactory.Create(binary, []string{"push", tag}, &command.Opts{
+				Stdout: output,
+				Stderr: output,
+			})
+			return pushCmd.Run()
+		})
+		if err != nil {
+			return fmt.Errorf("push %s: %w", tag, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type tlsMaterial struct {
+	// driverOpt is the --driver-opt key of the remote driver referencing the file
+	driverOpt string
+	fileName  string
+	value     string
+}
+
+// RemoteDriverOpts returns the TLS related --driver-opt values of the remote driver.
+// The certificate inputs accept either a file path or the PEM encoded content (for example from a secret env var),
+// the latter is written into a private temporary directory created by createTempDir, which is returned for cleanup.
+func RemoteDriverOpts(input Input, createTempDir func(prefix string) (string, error)) ([]string, string, error) {
+	materials := []tlsMaterial{
+		{driverOpt: "cacert", fileName: "ca.pem", value: string(input.BuildkitCACert)},
+		{driverOpt: "cert", fileName: "cert.pem", value: string(input.BuildkitClientCert)},
+		{driverOpt: "key", fileName: "key.pem", value: string(input.BuildkitClientKey)},
+	}
+
+	var opts []string
+	var tempDir string
+	for _, material := range materials {
+		if material.value == "" {
+			continue
+		}
+
+		path := material.value
+		if isPEMContent(material.value) {
+			if tempDir == "" {
+				dir, err := createTempDir("buildkit-tls")
+				if err != nil {
+					return nil, "", fmt.Errorf("create temp dir: %w", err)
+				}
+				tempDir = dir
+			}
+
+			path = filepath.Join(tempDir, material.fileName)
+			if err := os.WriteFile(path, []byte(material.value), 0600); err != nil {
+				return nil, tempDir, fmt.Errorf("write %s: %w", material.fileName, err)
+			}
+		} else {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return nil, tempDir, fmt.Errorf("resolve %s path: %w", material.driverOpt, err)
+			}
+			if _, err := os.Stat(absPath); err != nil {
+				return nil, tempDir, fmt.Errorf("%s file: %w", material.driverOpt, err)
+			}
+			path = absPath
+		}
+
+		opts = append(opts, fmt.Sprintf("%s=%s", material.driverOpt, path))
+	}
+
+	if input.BuildkitServerName != "" {
+		opts = append(opts, fmt.Sprintf("servername=%s", input.BuildkitServerName))
+	}
+
+	return opts, tempDir, nil
+}
+
+func validateRemoteInputs(input Input) error {
+	if input.BuildkitEndpoint == "" {
+		return fmt.Errorf("buildkit_endpoint is required for the %s driver", driverRemote)
+	}
+	if input.BuildkitImage != "" {
+		return fmt.Errorf("buildkit_image is not supported by the %s driver", driverRemote)
+	}
+
+	hasTLS := input.BuildkitCACert != "" || input.BuildkitClientCert != "" || input.BuildkitClientKey != ""
+	if hasTLS && !strings.HasPrefix(input.BuildkitEndpoint, "tcp://") {
+		return fmt.Errorf("TLS certificates are only supported for tcp:// BuildKit endpoints")
+	}
+	if (input.BuildkitClientCert == "") != (input.BuildkitClientKey == "") {
+		return fmt.Errorf("buildkit_client_cert and buildkit_client_key must be set together")
+	}
+
+	return nil
+}
+
+func isPEMContent(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
@@ -0,0 +1,70 @@
+package step
+
+import (
+	"fmt"
+)
+
+// builderCPUPeriod is the CFS scheduler period in microseconds the CPU quota is measured against,
+// it matches the Docker default so a quota of 150000 equals 1.5 CPUs.
+const builderCPUPeriod = 100000
+
+// ResourceDriverOpts returns the --driver-opt values limiting the resources of the BuildKit container.
+func ResourceDriverOpts(input Input) []string {
+	var opts []string
+	if input.BuilderCPUShares > 0 {
+		opts = append(opts, fmt.Sprintf("cpu-shares=%d", input.BuilderCPUShares))
+	}
+	if input.BuilderCPUQuota > 0 {
+		opts = append(opts, fmt.Sprintf("cpu-period=%d", builderCPUPeriod))
+		opts = append(opts, fmt.Sprintf("cpu-quota=%d", input.BuilderCPUQuota))
+	}
+	if input.BuilderMemory != "" {
+		opts = append(opts, fmt.Sprintf("memory=%s", input.BuilderMemory))
+	}
+	if input.BuilderCgroupParent != "" {
+		opts = append(opts, fmt.Sprintf("cgroup-parent=%s", input.BuilderCgroupParent))
+	}
+	return opts
+}
+
+func validateResourceInputs(input Input) error {
+	// Negative values produce no driver options, so they are checked first
+	if input.BuilderCPUShares < 0 || input.BuilderCPUQuota < 0 {
+		return fmt.Errorf("builder CPU shares and quota must not be negative")
+	}
+	if len(ResourceDriverOpts(input)) == 0 {
+		return nil
+	}
+	if input.Driver != driverDockerContainer {
+		return fmt.Errorf("builder resource limits are only supported by the %s driver", driverDockerContainer)
+	}
+	return nil
+}
+
+// warnIfOutOfMemory explains a failed build if it was caused by the memory limit of the builder.
+func (step DockerBuildPushStep) warnIfOutOfMemory(input Input, builder buildxBuilder, failures *FailureDetector) {
+	if input.Driver != driverDockerContainer {
+		return
+	}
+
+	oomKilled := failures.Category() == FailureOutOfMemory
+	if !oomKilled && builder.created {
+		container := builder.buildkitContainer()
+		cmd := step.commandFactory.Create("docker", []string{"inspect", "--format", "{{.State.OOMKilled}}", container}, nil)
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			step.logger.Debugf("Inspect BuildKit container %s: %s", container, out)
+		}
+		oomKilled = out == "true"
+	}
+
+	if !oomKilled {
+		return
+	}
+
+	if input.BuilderMemory != "" {
+		step.logger.Warnf("The build was killed because it ran out of memory, the builder memory limit is %s. Consider increasing builder_memory.", input.BuilderMemory)
+	} else {
+		step.logger.Warnf("The build was killed because it ran out of memory.")
+	}
+}
@@ -0,0 +1,182 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rootlessBuildkitImage = "moby/buildkit:rootless"
+	// rootlessBuildkitdConfigPath is where the rootless BuildKit image reads the daemon configuration from
+	rootlessBuildkitdConfigPath = "/home/user/.config/buildkit/buildkitd.toml"
+)
+
+// rootlessSecurityOpts are required by RootlessKit to create the user and mount namespaces
+// of the daemon without running the container privileged. /proc stays masked, as the daemon
+// runs with --oci-worker-no-process-sandbox instead of unmasking it for the process sandbox.
+var rootlessSecurityOpts = []string{
+	"seccomp=unconfined",
+	"apparmor=unconfined",
+}
+
+func validateRootlessInputs(input Input) error {
+	if !input.Rootless {
+		return nil
+	}
+
+	if input.Driver != driverDockerContainer {
+		return fmt.Errorf("rootless mode is only supported by the %s driver", driverDockerContainer)
+	}
+
+	var unsupported []string
+	if input.DriverOpts != "" {
+		unsupported = append(unsupported, "driver_opts")
+	}
+	if input.BuilderNodes != "" {
+		unsupported = append(unsupported, "builder_nodes")
+	}
+	if input.RegistryCACerts != "" {
+		unsupported = append(unsupported, "registry_ca_certs")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("the following inputs are not supported in rootless mode: %s", strings.Join(unsupported, ", "))
+	}
+
+	if input.BuildxHostNetwork {
+		return fmt.Errorf("the network.host entitlement requested by buildx_host_network is not available in rootless mode")
+	}
+	for _, option := range ParseExtraOptions(input.ExtraOptions) {
+		if strings.Contains(option, "network.host") || strings.Contains(option, "security.insecure") {
+			return fmt.Errorf("the %s entitlement is not available in rootless mode", option)
+		}
+	}
+
+	return nil
+}
+
+// checkRootlessSupport makes sure the kernel allows unprivileged users to create user namespaces.
+// The check is only possible when the Docker daemon runs on the same Linux host.
+func (step DockerBuildPushStep) checkRootlessSupport() error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	if host := step.envRepo.Get("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		step.logger.Warnf("Skipping the rootless support check, the Docker daemon (%s) is not local", host)
+		return nil
+	}
+
+	if value, err := readProcValue("/proc/sys/user/max_user_namespaces"); err == nil && value == 0 {
+		return fmt.Errorf("rootless mode requires user namespaces, but user.max_user_namespaces is 0")
+	}
+	// Only present on Debian and Ubuntu kernels
+	if value, err := readProcValue("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && value == 0 {
+		return fmt.Errorf("rootless mode requires unprivileged user namespaces, but kernel.unprivileged_userns_clone is 0")
+	}
+
+	return nil
+}
+
+func readProcValue(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// createRootlessBuilder starts an unprivileged BuildKit container and connects a remote driver builder to it,
+// as the docker-container driver of buildx always runs BuildKit privileged.
+func (step DockerBuildPushStep) createRootlessBuilder(input Input, caCerts []string) (buildxBuilder, error) {
+	if err := step.checkRootlessSupport(); err != nil {
+		return buildxBuilder{}, err
+	}
+
+	step.logger.Printf("Rootless mode limitations: the network.host and security.insecure entitlements are not available, " +
+		"and RUN instructions are not isolated in their own process namespace")
+
+	image := input.BuildkitImage
+	if image == "" {
+		image = rootlessBuildkitImage
+	} else if !strings.Contains(image, "rootless") {
+		step.logger.Warnf("The BuildKit image %s does not look like a rootless variant", image)
+	}
+
+	container := fmt.Sprintf("buildkit_rootless_%d", time.Now().UnixNano())
+	if input.BuilderName != "" {
+		container = fmt.Sprintf("buildkit_rootless_%s", input.BuilderName)
+	}
+
+	args := []string{"run", "--detach", "--name", container}
+	for _, opt := range rootlessSecurityOpts {
+		args = append(args, "--security-opt", opt)
+	}
+	// The resource driver options share their names with the docker run flags
+	for _, opt := range ResourceDriverOpts(input) {
+		args = append(args, "--"+opt)
+	}
+	if input.PropagateProxy {
+		for _, env := range ProxyEnvs(step.envRepo.Get) {
+			args = append(args, "--env", env)
+		}
+	}
+
+	var tempDir string
+	buildkitdConfig, err := ParseBuildkitdConfig(input)
+	if err != nil {
+		return buildxBuilder{}, fmt.Errorf("parse buildkitd config: %w", err)
+	}
+	if !buildkitdConfig.IsEmpty() {
+		configPath, err := step.writeBuildkitdConfig(buildkitdConfig)
+		if err != nil {
+			return buildxBuilder{}, fmt.Errorf("write buildkitd config: %w", err)
+		}
+		// The config is mounted into the container, so it is kept for the lifetime of the builder
+		tempDir = filepath.Dir(configPath)
+		args = append(args, "--volume", fmt.Sprintf("%s:%s:ro", configPath, rootlessBuildkitdConfigPath))
+	}
+
+	args = append(args, image, "--oci-worker-no-process-sandbox")
+
+	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+	runCmd := step.commandFactory.Create("docker", args, nil)
+	if out, err := runCmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		step.removeTempDir(tempDir)
+		return buildxBuilder{}, fmt.Errorf("start rootless BuildKit container %s: %w", out, err)
+	}
+
+	builder := buildxBuilder{created: true, tempDir: tempDir, container: container}
+
+	createArgs := []string{"buildx", "create", "--use", "--driver", driverRemote}
+	if input.BuilderName != "" {
+		createArgs = append(createArgs, "--name", input.BuilderName)
+	}
+	createArgs = append(createArgs, "docker-container://"+container)
+
+	name, err := step.runBuildxCreate(createArgs)
+	if err != nil {
+		step.removeBuildkitContainer(container)
+		step.removeTempDir(tempDir)
+		return buildxBuilder{}, err
+	}
+	builder.name = name
+
+	if err := step.installBuilderCACerts(builder, caCerts); err != nil {
+		step.releaseBuilder(builder, false)
+		return buildxBuilder{}, fmt.Errorf("install CA certificates: %w", err)
+	}
+
+	return builder, nil
+}
+
+func (step DockerBuildPushStep) removeBuildkitContainer(container string) {
+	cmd := step.commandFactory.Create("docker", []string{"rm", "--force", container}, nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		step.logger.Errorf("remove BuildKit container %s: %s", out, err)
+	}
+}
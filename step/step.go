@@ -1,10 +1,14 @@
 package step
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/cache"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
@@ -15,10 +19,35 @@ import (
 )
 
 type Input struct {
-	UseBitriseCache   bool `env:"use_bitrise_cache,required"`
-	Push              bool `env:"push,required"`
-	Verbose           bool `env:"verbose,required"`
-	BuildxHostNetwork bool `env:"buildx_host_network,required"`
+	Mode                string `env:"mode,opt[build,promote,merge-manifests]"`
+	SourceImage         string `env:"source_image"`
+	ManifestSources     string `env:"manifest_sources"`
+	ManifestSourcesFile string `env:"manifest_sources_file"`
+
+	UseBitriseCache bool `env:"use_bitrise_cache,required"`
+	Push            bool `env:"push,required"`
+	Load            bool `env:"load,required"`
+	VerifyPush      bool `env:"verify_push,required"`
+	Verbose         bool `env:"verbose,required"`
+	KeepBuilder     bool `env:"keep_builder,required"`
+	Rootless        bool `env:"rootless,required"`
+	// Deprecated: replaced by network=host in DriverOpts
+	BuildxHostNetwork bool `env:"buildx_host_network"`
+
+	FallbackToClassicBuilder bool `env:"fallback_to_classic_builder,required"`
+
+	Backend           string `env:"backend,opt[docker,podman,buildah]"`
+	DaemonWaitTimeout int    `env:"daemon_wait_timeout,range[0..3600]"`
+	MinFreeDiskSpace  string `env:"min_free_disk_space"`
+	PrunePolicy       string `env:"prune_policy,opt[none,dangling,aggressive]"`
+	BuildTimeout      int    `env:"build_timeout,range[0..86400]"`
+	PushRetries       int    `env:"push_retries,range[0..10]"`
+
+	MirrorRegistries    string `env:"mirror_registries"`
+	AllowMirrorFailures bool   `env:"allow_mirror_failures,required"`
+
+	OutputType        string `env:"output_type,opt[none,docker-tar,oci-layout,local-filesystem]"`
+	OutputCompression string `env:"output_compression,opt[none,gzip,zstd]"`
 
 	Tags         string `env:"tags,required"`
 	File         string `env:"file,required"`
@@ -27,16 +56,44 @@ type Input struct {
 	CacheFrom    string `env:"cache_from"`
 	CacheTo      string `env:"cache_to"`
 	ExtraOptions string `env:"extra_options"`
+	BuilderName  string `env:"builder_name"`
+	ImageInputs  string `env:"image_inputs"`
+
+	Driver           string `env:"driver,opt[docker,docker-container,remote]"`
+	DriverOpts       string `env:"driver_opts"`
+	BuildkitImage    string `env:"buildkit_image"`
+	BuildkitEndpoint string `env:"buildkit_endpoint"`
+
+	BuildkitCACert     stepconf.Secret `env:"buildkit_ca_cert"`
+	BuildkitClientCert stepconf.Secret `env:"buildkit_client_cert"`
+	BuildkitClientKey  stepconf.Secret `env:"buildkit_client_key"`
+	BuildkitServerName string          `env:"buildkit_server_name"`
+	BuilderNodes       string          `env:"builder_nodes"`
+
+	BuilderCPUShares    int    `env:"builder_cpu_shares"`
+	BuilderCPUQuota     int    `env:"builder_cpu_quota"`
+	BuilderMemory       string `env:"builder_memory"`
+	BuilderCgroupParent string `env:"builder_cgroup_parent"`
+
+	PropagateProxy bool   `env:"propagate_proxy,required"`
+	BuilderCACerts string `env:"builder_ca_certs"`
+
+	RegistryMirrors    string `env:"registry_mirrors"`
+	InsecureRegistries string `env:"insecure_registries"`
+	RegistryCACerts    string `env:"registry_ca_certs"`
+	GCKeepStorage      string `env:"gc_keep_storage"`
+	MaxParallelism     int    `env:"max_parallelism"`
 }
 
 type DockerBuildPushStep struct {
-	logger         log.Logger
-	inputParser    stepconf.InputParser
-	commandFactory command.Factory
-	pathChecker    pathutil.PathChecker
-	pathProvider   pathutil.PathProvider
-	pathModifier   pathutil.PathModifier
-	envRepo        env.Repository
+	logger          log.Logger
+	inputParser     stepconf.InputParser
+	commandFactory  command.Factory
+	commandCanceler CommandCanceler
+	pathChecker     pathutil.PathChecker
+	pathProvider    pathutil.PathProvider
+	pathModifier    pathutil.PathModifier
+	envRepo         env.Repository
 }
 
 const (
@@ -50,32 +107,46 @@ func New(
 	logger log.Logger,
 	inputParser stepconf.InputParser,
 	commandFactory command.Factory,
+	commandCanceler CommandCanceler,
 	pathChecker pathutil.PathChecker,
 	pathProvider pathutil.PathProvider,
 	pathModifier pathutil.PathModifier,
 	envRepo env.Repository,
 ) DockerBuildPushStep {
 	return DockerBuildPushStep{
-		logger:         logger,
-		inputParser:    inputParser,
-		commandFactory: commandFactory,
-		pathChecker:    pathChecker,
-		pathProvider:   pathProvider,
-		pathModifier:   pathModifier,
-		envRepo:        envRepo,
+		logger:          logger,
+		inputParser:     inputParser,
+		commandFactory:  commandFactory,
+		commandCanceler: commandCanceler,
+		pathChecker:     pathChecker,
+		pathProvider:    pathProvider,
+		pathModifier:    pathModifier,
+		envRepo:         envRepo,
 	}
 }
 
 func (step DockerBuildPushStep) Run() error {
+	err := step.run()
+	if err != nil {
+		step.exportFailedPhase(err)
+	}
+	return err
+}
+
+func (step DockerBuildPushStep) run() error {
 	var input Input
 	if err := step.inputParser.Parse(&input); err != nil {
-		return fmt.Errorf("parse inputs: %w", err)
+		return withPhase(PhaseValidation, fmt.Errorf("parse inputs: %w", err))
 	}
 	stepconf.Print(input)
 	step.logger.Println()
 
 	step.logger.EnableDebugLog(input.Verbose)
 
+	if input.Mode != modeBuild {
+		return step.runRegistryMode(input)
+	}
+
 	imageName := strings.Split(input.Tags, "\n")[0]
 
 	// We need to remove the image tag as it might change between builds
@@ -84,24 +155,112 @@ func (step DockerBuildPushStep) Run() error {
 		imageName = strings.Split(imageName, ":")[0]
 	}
 
+	if input.MinFreeDiskSpace != "" {
+		if _, err := ParseMinFreeDiskSpace(input.MinFreeDiskSpace); err != nil {
+			return withPhase(PhaseValidation, err)
+		}
+	}
+
+	var daemonInfo DockerDaemonInfo
+	if input.Backend == backendDocker {
+		info, err := step.waitForDockerDaemon(time.Duration(input.DaemonWaitTimeout) * time.Second)
+		if err != nil {
+			return withPhase(PhaseBuilderSetup, err)
+		}
+		daemonInfo = info
+	}
+
+	engine, err := step.selectBuildEngine(input)
+	if err != nil {
+		return withPhase(PhaseBuilderSetup, err)
+	}
+	if err := engine.validate(input); err != nil {
+		return withPhase(PhaseValidation, err)
+	}
+	step.logger.Println()
+
+	// Pruning is destructive, so it only happens once every input is known to be valid
+	if input.Backend == backendDocker {
+		if err := step.ensureDiskSpace(input, daemonInfo); err != nil {
+			return withPhase(PhaseBuilderSetup, err)
+		}
+	}
+
+	var metrics buildMetrics
+
 	if input.UseBitriseCache {
+		restoreStartTime := time.Now()
 		if err := step.restoreCache(input, imageName); err != nil {
-			return fmt.Errorf("restore cache: %w", err)
+			return withPhase(PhaseCacheRestore, fmt.Errorf("restore cache: %w", err))
 		}
+		metrics.cacheRestoreDuration = time.Since(restoreStartTime)
+
+		size, err := directorySize(dockerCacheFolder)
+		if err != nil {
+			step.logger.Warnf("Failed to measure cache size: %s", err)
+		}
+		metrics.cacheSizeBefore = size
 	}
 
-	if err := step.dockerBuild(input); err != nil {
-		return fmt.Errorf("build docker image: %w", err)
+	if err := step.commandCanceler.Err(); err != nil {
+		return withPhase(PhaseAborted, err)
+	}
+
+	observer := newBuildObserver()
+	err = step.runBuild(engine, input, observer)
+	metrics.cachedSteps, metrics.executedSteps = observer.progress.Counts()
+	if err != nil {
+		step.reportFailure(observer.failures)
+		return withPhase(PhaseBuild, fmt.Errorf("build image with %s: %w", engine.name(), err))
+	}
+
+	if err := step.commandCanceler.Err(); err != nil {
+		return withPhase(PhaseAborted, err)
 	}
 
 	if input.UseBitriseCache {
+		size, err := directorySize(dockerCacheFolder)
+		if err != nil {
+			step.logger.Warnf("Failed to measure cache size: %s", err)
+		}
+		metrics.cacheSizeAfter = size
+
+		saveStartTime := time.Now()
 		if err := step.saveCache(input, imageName); err != nil {
-			return fmt.Errorf("save cache: %w", err)
+			return withPhase(PhaseCacheSave, fmt.Errorf("save cache: %w", err))
 		}
+		metrics.cacheSaveDuration = time.Since(saveStartTime)
 	}
+
+	step.printMetrics(metrics)
+	if err := step.exportMetrics(metrics); err != nil {
+		return withPhase(PhaseOutputs, fmt.Errorf("export metrics: %w", err))
+	}
+
 	return nil
 }
 
+// runBuild runs the build of the engine, interrupting it when it does not finish within the build timeout.
+func (step DockerBuildPushStep) runBuild(engine buildEngine, input Input, observer buildObserver) error {
+	if input.BuildTimeout > 0 {
+		timeout := time.Duration(input.BuildTimeout) * time.Second
+		timer := time.AfterFunc(timeout, func() {
+			step.logger.Errorf("The build did not finish in %s, aborting...", timeout)
+			step.commandCanceler.CancelRunning(fmt.Errorf("%w after %s", ErrBuildTimeout, timeout))
+		})
+		defer timer.Stop()
+	}
+
+	err := engine.build(input, observer)
+	if err != nil {
+		// Report the cancellation instead of the failure of the interrupted command
+		if reason := step.commandCanceler.Err(); reason != nil && !errors.Is(err, reason) {
+			return fmt.Errorf("%w: %s", reason, err)
+		}
+	}
+	return err
+}
+
 func (step DockerBuildPushStep) restoreCache(input Input, imageName string) error {
 	step.logger.Infof("Restoring cache...")
 	restorer := cache.NewRestorer(step.envRepo, step.logger, step.commandFactory)
@@ -132,7 +291,7 @@ func (step DockerBuildPushStep) saveCache(input Input, imageName string) error {
 	})
 }
 
-func (step DockerBuildPushStep) dockerBuild(input Input) error {
+func (step DockerBuildPushStep) dockerBuild(input Input, capabilities DockerCapabilities, observer buildObserver) error {
 	step.logger.Infof("Building docker image...")
 
 	if err := step.createCacheFolder(dockerCacheFolder); err != nil {
@@ -141,18 +300,79 @@ func (step DockerBuildPushStep) dockerBuild(input Input) error {
 	if err := step.createCacheFolder(dockerCacheFolderTemporary); err != nil {
 		return fmt.Errorf("create cache folder: %w", err)
 	}
+	// The temporary cache folder is only left behind when the build fails or is aborted
+	defer step.removeTempDir(dockerCacheFolderTemporary)
+
+	builder, err := step.initializeBuildkit(input)
+	if err != nil {
+		return withPhase(PhaseBuilderSetup, fmt.Errorf("initialize buildkit: %w", err))
+	}
+	defer func() {
+		// Builders are not kept after an abort or timeout, as no subsequent step would reuse them
+		step.releaseBuilder(builder, input.KeepBuilder && step.commandCanceler.Err() == nil)
+	}()
+
+	if err := step.exportBuilderName(builder.name); err != nil {
+		return fmt.Errorf("export builder name: %w", err)
+	}
 
-	buildkitContainer, err := step.initializeBuildkit(input)
+	// Commands started after an abort or timeout run normally, so every phase checks for the cancellation first
+	if err := step.commandCanceler.Err(); err != nil {
+		return err
+	}
+	buildkitVersion, err := step.probeBuildkitVersion(builder.name)
+	if err != nil {
+		return withPhase(PhaseBuilderSetup, fmt.Errorf("probe buildkit version: %w", err))
+	}
+	if buildkitVersion != "" {
+		step.logger.Printf("BuildKit version: %s", buildkitVersion)
+	} else {
+		step.logger.Warnf("The BuildKit version of buildx instance %s is unknown", builder.name)
+	}
+	if err := step.exportBuildkitVersion(buildkitVersion); err != nil {
+		return fmt.Errorf("export buildkit version: %w", err)
+	}
+	capabilities = capabilities.WithBuildkitVersion(buildkitVersion)
+	if err := ValidateFeatureSupport(input, capabilities); err != nil {
+		return withPhase(PhaseValidation, err)
+	}
+
+	if err := step.commandCanceler.Err(); err != nil {
+		return err
+	}
+	imageInputs, err := ParseImageInputs(input.ImageInputs)
 	if err != nil {
-		return fmt.Errorf("initialize buildkit: %w", err)
+		return err
 	}
+	if err := step.loadImageInputs("docker", imageInputs); err != nil {
+		return fmt.Errorf("load image inputs: %w", err)
+	}
+
+	var contextsDir string
 	defer func() {
-		if err := step.destroyContainer(buildkitContainer); err != nil {
-			step.logger.Errorf("destroy buildx instance: %s", err)
+		if contextsDir != "" {
+			step.removeTempDir(contextsDir)
 		}
 	}()
+	buildContexts, err := step.prepareBuildContexts(imageInputs, func() (string, error) {
+		if contextsDir != "" {
+			return contextsDir, nil
+		}
+		dir, err := step.pathProvider.CreateTempDir("image-inputs")
+		contextsDir = dir
+		return dir, err
+	})
+	if err != nil {
+		return fmt.Errorf("prepare build contexts: %w", err)
+	}
 
-	if err := step.build(input); err != nil {
+	if err := step.commandCanceler.Err(); err != nil {
+		return err
+	}
+	target := buildxTarget{builder: builder.name, buildContexts: buildContexts}
+	built, err := step.build(input, target, capabilities, observer.output())
+	if err != nil {
+		step.warnIfOutOfMemory(input, builder, observer.failures)
 		return fmt.Errorf("build docker image: %w", err)
 	}
 
@@ -162,103 +382,208 @@ func (step DockerBuildPushStep) dockerBuild(input Input) error {
 		return fmt.Errorf("move cache folder: %w", err)
 	}
 
-	return nil
-}
-
-func (step DockerBuildPushStep) destroyContainer(container string) error {
-	args := []string{
-		"buildx", "rm", "--force", container,
-	}
-	cmd := step.commandFactory.Create("docker", args, nil)
-	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	artifact, exportArtifact, err := ParseImageArtifact(input, step.envRepo.Get("BITRISE_DEPLOY_DIR"))
 	if err != nil {
-		return fmt.Errorf("remove buildx instance %s: %w", out, err)
+		return err
 	}
-	return nil
-}
-
-func (step DockerBuildPushStep) build(input Input) error {
-	args := []string{
-		"buildx",
-		"build",
+	if exportArtifact {
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		if err := step.exportImageArtifact(input, target, artifact, built, observer.exportOutput()); err != nil {
+			return fmt.Errorf("export image artifact: %w", err)
+		}
 	}
 
-	if input.BuildArg != "" {
-		for _, arg := range strings.Split(input.BuildArg, "\n") {
-			args = append(args, "--build-arg", arg)
+	if input.Push {
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		digest, err := step.push(input, target, built, observer.exportOutput())
+		if err != nil {
+			return withPhase(PhasePush, fmt.Errorf("push docker image: %w", err))
+		}
+		if err := step.exportImageDigest(digest); err != nil {
+			return err
+		}
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		if input.VerifyPush {
+			if err := step.verifyPushedTags(splitLines(input.Tags), digest, RequestedPlatforms(input)); err != nil {
+				return withPhase(PhasePush, fmt.Errorf("verify pushed image: %w", err))
+			}
+		}
+		if err := step.commandCanceler.Err(); err != nil {
+			return err
+		}
+		if input.MirrorRegistries != "" {
+			if err := step.mirrorImage(input, digest, RequestedPlatforms(input), observer.exportOutput()); err != nil {
+				return withPhase(PhasePush, fmt.Errorf("mirror image: %w", err))
+			}
 		}
 	}
 
+	return nil
+}
+
+// build builds the image and returns its metadata, the subsequent pushing and exporting builds are checked against it.
+func (step DockerBuildPushStep) build(input Input, target buildxTarget, capabilities DockerCapabilities, output io.Writer) (BuildMetadata, error) {
+	var cacheArgs []string
 	switch {
 	case input.UseBitriseCache:
-		args = append(args, fmt.Sprintf("--cache-from=type=local,src=%s", dockerCacheFolder))
-		args = append(args, fmt.Sprintf("--cache-to=type=local,dest=%s,mode=max,compression=zstd", dockerCacheFolderTemporary))
+		compression := "zstd"
+		if !capabilities.ZstdCacheCompression {
+			step.logger.Warnf("%s does not support zstd cache compression, falling back to gzip", capabilities.versions())
+			compression = "gzip"
+		}
+		cacheArgs = append(cacheArgs, fmt.Sprintf("--cache-from=type=local,src=%s", dockerCacheFolder))
+		cacheArgs = append(cacheArgs, fmt.Sprintf("--cache-to=type=local,dest=%s,mode=max,compression=%s", dockerCacheFolderTemporary, compression))
 	case input.CacheFrom != "":
 		for _, cacheFrom := range strings.Split(input.CacheFrom, "\n") {
-			args = append(args, fmt.Sprintf("--cache-from=%s", cacheFrom))
+			cacheArgs = append(cacheArgs, fmt.Sprintf("--cache-from=%s", cacheFrom))
 		}
 		fallthrough
 	case input.CacheTo != "":
 		for _, cacheTo := range strings.Split(input.CacheTo, "\n") {
-			args = append(args, fmt.Sprintf("--cache-to=%s", cacheTo))
+			cacheArgs = append(cacheArgs, fmt.Sprintf("--cache-to=%s", cacheTo))
 		}
 	}
 
-	if input.ExtraOptions != "" {
-		options := ParseExtraOptions(input.ExtraOptions)
-		if len(options) > 0 {
-			args = append(args, options...)
-		}
+	metadataFile, err := step.createMetadataFile("build-metadata")
+	if err != nil {
+		return BuildMetadata{}, err
 	}
+	defer step.removeTempDir(filepath.Dir(metadataFile))
 
-	if input.Push {
-		args = append(args, "--push")
-	} else {
+	// The image is pushed by a subsequent build, so a failed push can be retried without rebuilding the image,
+	// the build steps of the push are served from the cache of the builder
+	outputArgs := []string{"--metadata-file", metadataFile}
+	if input.Load || !input.Push {
 		// The --load parameter is used to load the image into the local docker daemon
 		// This is needed because the docker buildx build command will keep the result in cache only,
 		// preventing the use of the image in the same build
-		args = append(args, "--load")
+		outputArgs = append(outputArgs, "--load")
+	} else {
+		// Exporting the image without pushing it reports its digest, so the push can be checked against it
+		outputArgs = append(outputArgs, "--output", "type=image,push=false")
 	}
 
-	for _, tag := range strings.Split(input.Tags, "\n") {
-		args = append(args, "--tag", tag)
+	args := step.buildxBuildArgs(input, target, cacheArgs, outputArgs, false)
+	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+	buildxCmd := step.commandFactory.Create("docker", args, &command.Opts{
+		Stdout: output,
+		Stderr: output,
+	})
+
+	if err := buildxCmd.Run(); err != nil {
+		return BuildMetadata{}, fmt.Errorf("build docker image with buildx: %w", err)
 	}
 
-	args = append(args, []string{"-f", input.File, input.Context}...)
+	return readBuildMetadata(metadataFile)
+}
 
-	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+// push pushes the image built by build and returns its digest. The build steps are served from the cache
+// of the builder, so only the export and the upload of the image are run again.
+func (step DockerBuildPushStep) push(input Input, target buildxTarget, built BuildMetadata, output io.Writer) (string, error) {
+	step.logger.Println()
+	step.logger.Infof("Pushing docker image...")
 
-	buildxCmd := step.commandFactory.Create("docker", args, &command.Opts{
-		Stdout: os.Stdout,
-		Stderr: os.Stdout,
+	metadataFile, err := step.createMetadataFile("push-metadata")
+	if err != nil {
+		return "", err
+	}
+	defer step.removeTempDir(filepath.Dir(metadataFile))
+
+	args := step.buildxBuildArgs(input, target, nil, []string{"--push", "--metadata-file", metadataFile}, true)
+	image := strings.Split(input.Tags, "\n")[0]
+	err = step.pushWithRetry(input, image, output, func(output io.Writer) error {
+		step.logger.Infof("$ docker %s", strings.Join(args, " "))
+
+		buildxCmd := step.commandFactory.Create("docker", args, &command.Opts{
+			Stdout: output,
+			Stderr: output,
+		})
+		return buildxCmd.Run()
 	})
+	if err != nil {
+		return "", err
+	}
+
+	pushed, err := readBuildMetadata(metadataFile)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckRebuild(built, pushed); err != nil {
+		return "", err
+	}
+	if pushed.ImageDigest == "" {
+		return "", fmt.Errorf("the build metadata contains no image digest")
+	}
+	return pushed.ImageDigest, nil
+}
 
-	err := buildxCmd.Run()
+// createMetadataFile returns the path of a buildx --metadata-file in a new temp dir, which is removed by the caller.
+func (step DockerBuildPushStep) createMetadataFile(prefix string) (string, error) {
+	dir, err := step.pathProvider.CreateTempDir(prefix)
 	if err != nil {
-		return fmt.Errorf("build docker image with buildx: %w", err)
+		return "", fmt.Errorf("create temp dir: %w", err)
 	}
+	return filepath.Join(dir, "metadata.json"), nil
+}
 
-	return nil
+// buildxTarget is the builder and the build contexts shared by the builds of the image,
+// the builds pushing or exporting the image need the same build contexts to be served from cache.
+type buildxTarget struct {
+	builder       string
+	buildContexts []string
 }
 
-func (step DockerBuildPushStep) initializeBuildkit(input Input) (string, error) {
+// buildxBuildArgs returns the arguments of a buildx build of the image. Reruns, which push or export the image
+// after it was built, drop the options disabling the cache, as they would rebuild the image from scratch.
+func (step DockerBuildPushStep) buildxBuildArgs(input Input, target buildxTarget, cacheArgs, outputArgs []string, rerun bool) []string {
 	args := []string{
-		"buildx", "create", "--use",
+		"buildx",
+		"build",
+		"--builder", target.builder,
+		// The plain progress output is parsed to collect the cached and executed build step counts
+		"--progress=plain",
 	}
 
-	if input.BuildxHostNetwork {
-		args = append(args, "--driver-opt", "network=host", "--buildkitd-flags", "--allow-insecure-entitlement network.host")
+	for _, context := range target.buildContexts {
+		args = append(args, "--build-context", context)
 	}
 
-	createCmd := step.commandFactory.Create("docker", args, nil)
+	if input.BuildArg != "" {
+		for _, arg := range strings.Split(input.BuildArg, "\n") {
+			args = append(args, "--build-arg", arg)
+		}
+	}
 
-	step.logger.Infof("$ docker %s", strings.Join(args, " "))
+	for _, arg := range step.proxyBuildArgs(input) {
+		args = append(args, "--build-arg", arg)
+	}
 
-	out, err := createCmd.RunAndReturnTrimmedCombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("create buildx instance %s: %w", out, err)
+	args = append(args, cacheArgs...)
+
+	if input.ExtraOptions != "" {
+		options := ParseExtraOptions(input.ExtraOptions)
+		if rerun {
+			options = StripNoCacheOptions(options)
+		}
+		if len(options) > 0 {
+			args = append(args, options...)
+		}
+	}
+
+	args = append(args, outputArgs...)
+
+	for _, tag := range strings.Split(input.Tags, "\n") {
+		args = append(args, "--tag", tag)
 	}
-	return out, nil
+
+	return append(args, []string{"-f", input.File, input.Context}...)
 }
 
 func (step DockerBuildPushStep) createCacheFolder(path string) error {
@@ -299,3 +624,23 @@ func ParseExtraOptions(options string) []string {
 
 	return optionArgs
 }
+
+// StripNoCacheOptions removes --no-cache and --no-cache-filter from the parsed extra options.
+func StripNoCacheOptions(options []string) []string {
+	var stripped []string
+	for i := 0; i < len(options); i++ {
+		name, _, hasValue := strings.Cut(options[i], "=")
+		switch name {
+		case "--no-cache":
+			continue
+		case "--no-cache-filter":
+			if !hasValue {
+				// Skip the value passed as a separate argument
+				i++
+			}
+			continue
+		}
+		stripped = append(stripped, options[i])
+	}
+	return stripped
+}
@@ -0,0 +1,205 @@
+package step
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+)
+
+const imageDigestOutputKey = "DOCKER_IMAGE_DIGEST"
+
+// PushedManifest is the manifest (or index) of a tag, as resolved by `docker buildx imagetools inspect`.
+type PushedManifest struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// Platforms returns the platforms of the images in the index, attestation manifests are skipped.
+// It is empty if the tag points to a single image manifest.
+func (m PushedManifest) Platforms() []string {
+	var platforms []string
+	for _, manifest := range m.Manifests {
+		platform := manifest.Platform
+		if platform.OS == "" || platform.OS == "unknown" {
+			continue
+		}
+		name := platform.OS + "/" + platform.Architecture
+		if platform.Variant != "" {
+			name += "/" + platform.Variant
+		}
+		platforms = append(platforms, name)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// TagVerification is the result of checking a pushed tag against the build metadata.
+type TagVerification struct {
+	Tag       string
+	Digest    string
+	Platforms []string
+	// Problem describes why the tag does not match the build, it is empty if it does
+	Problem string
+}
+
+// VerifyPushedManifest checks the manifest resolved for the tag against the digest of the build metadata,
+// and against the requested platforms if the build requested any.
+func VerifyPushedManifest(tag string, manifest PushedManifest, expectedDigest string, expectedPlatforms []string) TagVerification {
+	verification := TagVerification{Tag: tag, Digest: manifest.Digest, Platforms: manifest.Platforms()}
+
+	if manifest.Digest != expectedDigest {
+		verification.Problem = fmt.Sprintf("digest %s does not match the built digest %s", manifest.Digest, expectedDigest)
+		return verification
+	}
+
+	// Single platform images pushed as a plain manifest carry no platform information
+	if len(expectedPlatforms) == 0 || (len(manifest.Manifests) == 0 && len(expectedPlatforms) == 1) {
+		return verification
+	}
+
+	expected := append([]string(nil), expectedPlatforms...)
+	sort.Strings(expected)
+	if strings.Join(expected, ",") != strings.Join(verification.Platforms, ",") {
+		verification.Problem = fmt.Sprintf("platforms %s do not match the requested platforms %s",
+			formatPlatforms(verification.Platforms), formatPlatforms(expected))
+	}
+
+	return verification
+}
+
+func formatPlatforms(platforms []string) string {
+	if len(platforms) == 0 {
+		return "[]"
+	}
+	return "[" + strings.Join(platforms, ", ") + "]"
+}
+
+// RequestedPlatforms returns the platforms set by the --platform extra option.
+func RequestedPlatforms(input Input) []string {
+	var platforms []string
+	options := ParseExtraOptions(input.ExtraOptions)
+	for i, option := range options {
+		var value string
+		switch {
+		case strings.HasPrefix(option, "--platform="):
+			value = strings.TrimPrefix(option, "--platform=")
+		case option == "--platform" && i+1 < len(options):
+			value = options[i+1]
+		default:
+			continue
+		}
+
+		for _, platform := range strings.Split(strings.Trim(value, `"'`), ",") {
+			if platform = strings.TrimSpace(platform); platform != "" {
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+	return platforms
+}
+
+// exportImageDigest exports the digest of the pushed image, or of the image the created tags point to.
+func (step DockerBuildPushStep) exportImageDigest(digest string) error {
+	step.logger.Printf("Image digest: %s", digest)
+
+	exporter := export.NewExporter(step.commandFactory)
+	if err := exporter.ExportOutput(imageDigestOutputKey, digest); err != nil {
+		return fmt.Errorf("export %s: %w", imageDigestOutputKey, err)
+	}
+	return nil
+}
+
+// BuildMetadata is the part of the buildx --metadata-file used by the step.
+type BuildMetadata struct {
+	// ImageDigest is the digest of the exported manifest or index, it depends on the exporter
+	ImageDigest string `json:"containerimage.digest"`
+	// ConfigDigest is the digest of the image config, which identifies the built image independently
+	// of the exporter, the layer compression and the attestations. It is only reported for single platform builds.
+	ConfigDigest string `json:"containerimage.config.digest"`
+}
+
+// ParseBuildMetadata parses the content of the buildx --metadata-file.
+func ParseBuildMetadata(content []byte) (BuildMetadata, error) {
+	var metadata BuildMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return BuildMetadata{}, fmt.Errorf("parse build metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+func readBuildMetadata(path string) (BuildMetadata, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return BuildMetadata{}, fmt.Errorf("read build metadata: %w", err)
+	}
+	return ParseBuildMetadata(content)
+}
+
+// CheckRebuild returns an error when the image produced by a rerun, which should have been served
+// from the cache of the build, differs from the built image, for example because the cache was evicted
+// meanwhile or the build is not reproducible (--pull, ADD of a remote URL).
+func CheckRebuild(built, rerun BuildMetadata) error {
+	if built.ConfigDigest == "" || rerun.ConfigDigest == "" || built.ConfigDigest == rerun.ConfigDigest {
+		return nil
+	}
+	return fmt.Errorf("the image was rebuilt instead of being served from the build cache and differs from the built image (config %s instead of %s)",
+		rerun.ConfigDigest, built.ConfigDigest)
+}
+
+// inspectPushedTag resolves the manifest of the tag from the registry.
+func (step DockerBuildPushStep) inspectPushedTag(tag string) (PushedManifest, error) {
+	args := []string{"buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", tag}
+	cmd := step.commandFactory.Create("docker", args, nil)
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return PushedManifest{}, fmt.Errorf("%s: %w", out, err)
+	}
+
+	var manifest PushedManifest
+	if err := json.Unmarshal([]byte(out), &manifest); err != nil {
+		return PushedManifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// verifyPushedTags checks that every tag resolves to the pushed image on the registry, and prints a report per tag.
+// The platforms are only checked if any is given.
+func (step DockerBuildPushStep) verifyPushedTags(tags []string, digest string, platforms []string) error {
+	step.logger.Println()
+	step.logger.Infof("Verifying pushed tags...")
+
+	var failed []string
+	for _, tag := range tags {
+		var verification TagVerification
+		manifest, err := step.inspectPushedTag(tag)
+		if err != nil {
+			verification = TagVerification{Tag: tag, Problem: fmt.Sprintf("failed to resolve the tag: %s", err)}
+		} else {
+			verification = VerifyPushedManifest(tag, manifest, digest, platforms)
+		}
+
+		if verification.Problem != "" {
+			failed = append(failed, tag)
+			step.logger.Errorf("%s: %s", tag, verification.Problem)
+			continue
+		}
+		step.logger.Donef("%s: %s %s", tag, verification.Digest, formatPlatforms(verification.Platforms))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of the pushed tags do not resolve to the built image: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package step
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/export"
+)
+
+const (
+	dockerVersionOutputKey   = "DOCKER_VERSION"
+	buildxVersionOutputKey   = "DOCKER_BUILDX_VERSION"
+	buildkitVersionOutputKey = "DOCKER_BUILDKIT_VERSION"
+)
+
+// Minimum buildx versions of the features used by the step
+var (
+	minBuildxZstdCacheCompression = Version{Major: 0, Minor: 8}
+	minBuildxAttestations         = Version{Major: 0, Minor: 10}
+	minBuildxCheck                = Version{Major: 0, Minor: 14}
+	minBuildxCall                 = Version{Major: 0, Minor: 15}
+)
+
+// Minimum BuildKit versions of the features which are implemented by the BuildKit daemon and not only by buildx
+var (
+	minBuildkitZstdCacheCompression = Version{Major: 0, Minor: 10}
+	minBuildkitAttestations         = Version{Major: 0, Minor: 11}
+)
+
+// Version is a semantic version of the Docker tooling, pre-release and build metadata are ignored.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ParseVersion parses versions like `24.0.7`, `20.10.21+dfsg1` or `v0.12.1-desktop.4`.
+func ParseVersion(s string) (Version, bool) {
+	match := versionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return Version{}, false
+	}
+
+	var version Version
+	version.Major, _ = strconv.Atoi(match[1])
+	version.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		version.Patch, _ = strconv.Atoi(match[3])
+	}
+	return version, true
+}
+
+func (v Version) AtLeast(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// DockerCapabilities are the features supported by the installed Docker tooling.
+type DockerCapabilities struct {
+	DockerVersion string
+	// BuildxVersion is empty when the buildx plugin is not installed
+	BuildxVersion string
+	// BuildkitVersion is empty until the builder is bootstrapped, or when the nodes do not report it
+	BuildkitVersion string
+
+	ZstdCacheCompression bool
+	Attestations         bool
+	Check                bool
+	Call                 bool
+}
+
+func (c DockerCapabilities) buildxAvailable() bool {
+	return c.BuildxVersion != ""
+}
+
+// NewDockerCapabilities derives the capability flags from the detected versions.
+// Unparsable buildx versions (for example development builds) are assumed to support every feature.
+func NewDockerCapabilities(dockerVersion, buildxVersion string) DockerCapabilities {
+	capabilities := DockerCapabilities{
+		DockerVersion: dockerVersion,
+		BuildxVersion: buildxVersion,
+	}
+	if buildxVersion == "" {
+		return capabilities
+	}
+
+	version, ok := ParseVersion(buildxVersion)
+	supports := func(min Version) bool {
+		return !ok || version.AtLeast(min)
+	}
+
+	capabilities.ZstdCacheCompression = supports(minBuildxZstdCacheCompression)
+	capabilities.Attestations = supports(minBuildxAttestations)
+	capabilities.Check = supports(minBuildxCheck)
+	capabilities.Call = supports(minBuildxCall)
+
+	return capabilities
+}
+
+// WithBuildkitVersion restricts the capabilities to the features supported by the BuildKit daemon of the builder.
+// Unknown or unparsable BuildKit versions are assumed to support every feature.
+func (c DockerCapabilities) WithBuildkitVersion(buildkitVersion string) DockerCapabilities {
+	c.BuildkitVersion = buildkitVersion
+
+	version, ok := ParseVersion(buildkitVersion)
+	if !ok {
+		return c
+	}
+	c.ZstdCacheCompression = c.ZstdCacheCompression && version.AtLeast(minBuildkitZstdCacheCompression)
+	c.Attestations = c.Attestations && version.AtLeast(minBuildkitAttestations)
+
+	return c
+}
+
+func (c DockerCapabilities) versions() string {
+	if c.BuildkitVersion == "" {
+		return fmt.Sprintf("buildx %s", c.BuildxVersion)
+	}
+	return fmt.Sprintf("buildx %s and BuildKit %s", c.BuildxVersion, c.BuildkitVersion)
+}
+
+// probeCapabilities detects the available Docker tooling once, before any build related command is run.
+func (step DockerBuildPushStep) probeCapabilities() (DockerCapabilities, error) {
+	dockerCmd := step.commandFactory.Create("docker", []string{"version", "--format", "{{.Server.Version}}"}, nil)
+	dockerVersion, err := dockerCmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return DockerCapabilities{}, fmt.Errorf("docker is not available or the daemon is not running: %s: %w", dockerVersion, err)
+	}
+	step.logger.Printf("Docker version: %s", dockerVersion)
+
+	buildxVersion, err := step.probeBuildxVersion()
+	if err != nil {
+		step.logger.Warnf("The buildx plugin is not available: %s", err)
+	} else {
+		step.logger.Printf("Buildx version: %s", buildxVersion)
+	}
+
+	capabilities := NewDockerCapabilities(dockerVersion, buildxVersion)
+
+	exporter := export.NewExporter(step.commandFactory)
+	if err := exporter.ExportOutput(dockerVersionOutputKey, capabilities.DockerVersion); err != nil {
+		return DockerCapabilities{}, fmt.Errorf("export %s: %w", dockerVersionOutputKey, err)
+	}
+	if err := exporter.ExportOutput(buildxVersionOutputKey, capabilities.BuildxVersion); err != nil {
+		return DockerCapabilities{}, fmt.Errorf("export %s: %w", buildxVersionOutputKey, err)
+	}
+
+	return capabilities, nil
+}
+
+// probeBuildxVersion returns the version of the buildx plugin, which does not need the Docker daemon to be running.
+func (step DockerBuildPushStep) probeBuildxVersion() (string, error) {
+	cmd := step.commandFactory.Create("docker", []string{"buildx", "version"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", out, err)
+	}
+	return ParseBuildxVersion(out), nil
+}
+
+func (step DockerBuildPushStep) exportBuildkitVersion(version string) error {
+	exporter := export.NewExporter(step.commandFactory)
+	return exporter.ExportOutput(buildkitVersionOutputKey, version)
+}
+
+// ValidateFeatureSupport checks that the features requested by the inputs are supported by the installed buildx,
+// and by the BuildKit daemon of the builder once its version is known.
+// Features which can be degraded gracefully, like the compression of the Bitrise cache, are not reported.
+func ValidateFeatureSupport(input Input, capabilities DockerCapabilities) error {
+	if !capabilities.buildxAvailable() {
+		return nil
+	}
+
+	var errs []string
+	require := func(feature string, supported bool, minBuildx Version, minBuildkit *Version) {
+		if supported {
+			return
+		}
+		if minBuildkit == nil {
+			errs = append(errs, fmt.Sprintf("%s requires buildx %s or newer", feature, minBuildx))
+			return
+		}
+		errs = append(errs, fmt.Sprintf("%s requires buildx %s and BuildKit %s or newer", feature, minBuildx, *minBuildkit))
+	}
+
+	for _, cacheTo := range splitLines(input.CacheTo) {
+		if strings.Contains(cacheTo, "compression=zstd") {
+			require("zstd cache compression (cache_to)", capabilities.ZstdCacheCompression, minBuildxZstdCacheCompression, &minBuildkitZstdCacheCompression)
+			break
+		}
+	}
+
+	for _, option := range ParseExtraOptions(input.ExtraOptions) {
+		name, _, _ := strings.Cut(option, "=")
+		switch name {
+		case "--attest", "--provenance", "--sbom":
+			require(fmt.Sprintf("attestations (%s)", name), capabilities.Attestations, minBuildxAttestations, &minBuildkitAttestations)
+		case "--check":
+			require("--check", capabilities.Check, minBuildxCheck, nil)
+		case "--call":
+			require("--call", capabilities.Call, minBuildxCall, nil)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unsupported features with %s: %s", capabilities.versions(), strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ParseBuildxVersion extracts the version from the `docker buildx version` output,
+// for example `github.com/docker/buildx v0.12.1 30feaa1` results in `v0.12.1`.
+func ParseBuildxVersion(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) >= 2 {
+		return fields[1]
+	}
+	return strings.TrimSpace(output)
+}